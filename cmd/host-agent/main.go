@@ -10,6 +10,7 @@ import (
 	"runtime"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/middleware-labs/mw-agent/pkg/agent"
 	"github.com/middleware-labs/synthetics-agent/pkg/worker"
@@ -25,15 +26,21 @@ import (
 
 var agentVersion = "0.0.1"
 
+// trackingCloseTimeout bounds the wait for the tracking events on shutdown
+const trackingCloseTimeout = 2 * time.Second
+
 type program struct {
 	logger    *zap.Logger
 	hostAgent *agent.HostAgent
 	programWG *sync.WaitGroup
-	// stop the telemetry collection if errCh receives an error
-	// resume when errCh receives nil
+	// errCh controls the telemetry collection. nil resumes it, the errors
+	// exported by pkg/agent (e.g. agent.ErrTransient) are handled as per
+	// their type and any other error stops it
 	errCh  chan error
 	stopCh chan struct{}
 	args   []string
+	// statusServer is nil if the status server is disabled
+	statusServer *agent.StatusServer
 }
 
 // Service interface for kardianos/service package to run
@@ -45,8 +52,20 @@ func (p *program) Start(s service.Service) error {
 	p.programWG.Add(1)
 	go p.run()
 
+	if p.statusServer != nil {
+		go func() {
+			if err := p.statusServer.Serve(); err != nil {
+				p.logger.Error("status server failed", zap.Error(err))
+			}
+		}()
+	}
+
 	// Start any goroutines that can control collection
-	if p.hostAgent.FetchAccountOtelConfig {
+	if p.hostAgent.OfflineBundle != "" {
+		// Apply the otel config from the local config bundle
+		// without contacting Middleware backend
+		p.errCh <- p.hostAgent.ApplyOfflineBundle()
+	} else if p.hostAgent.FetchAccountOtelConfig {
 		// Listen to the config changes provided by Middleware API
 		p.programWG.Add(1)
 		go func() {
@@ -68,6 +87,22 @@ func (p *program) Stop(s service.Service) error {
 	close(p.stopCh)
 	close(p.errCh)
 	p.programWG.Wait()
+
+	if p.statusServer != nil {
+		if err := p.statusServer.Close(); err != nil {
+			p.logger.Error("failed to close status server", zap.Error(err))
+		}
+	}
+
+	// give the shutdown event, and any event still pending, a bounded
+	// time to reach the backend. The events which don't make it are queued
+	// on disk for the next start.
+	p.hostAgent.TrackEventAsync(agent.TrackEventShutdown, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), trackingCloseTimeout)
+	defer cancel()
+	if err := p.hostAgent.Close(ctx); err != nil {
+		p.logger.Warn("failed to track agent shutdown", zap.Error(err))
+	}
 	return nil
 }
 
@@ -81,6 +116,26 @@ func (p *program) run() {
 		if errors.Is(err, agent.ErrInvalidConfig) {
 			p.logger.Error("invalid config. keeping collector in its current state",
 				zap.Error(err))
+			p.hostAgent.RecordDecision(agent.DecisionKeep, err)
+			continue
+		}
+
+		// if the backend is temporarily unavailable (e.g. DNS failure, 5xx from
+		// the backend or a flaky proxy), keep collecting with the current config.
+		if errors.Is(err, agent.ErrTransient) {
+			p.logger.Warn("backend temporarily unavailable. keeping collector in its current state",
+				zap.Error(err))
+			p.hostAgent.RecordDecision(agent.DecisionKeep, err)
+			continue
+		}
+
+		// if the API key is rejected, the backend won't accept any telemetry data.
+		// Stop collection until the backend accepts the API key again.
+		if errors.Is(err, agent.ErrAuthRevoked) {
+			p.logger.Error("api key rejected by backend. stopping collector until it is accepted",
+				zap.Error(err))
+			p.hostAgent.StopCollector(err)
+			p.hostAgent.RecordDecision(agent.DecisionStop, err)
 			continue
 		}
 
@@ -91,14 +146,19 @@ func (p *program) run() {
 			// if err is not agent.ErrRestartAgent, then keep collector stopped.
 			// if err is agent.ErrRestartAgent, then resume collection.
 			if !errors.Is(err, agent.ErrRestartAgent) {
+				p.hostAgent.RecordDecision(agent.DecisionStop, err)
 				continue
 			}
 			p.logger.Info("restarting collector", zap.Error(err))
+			p.hostAgent.RecordDecision(agent.DecisionRestart, err)
+		} else {
+			p.hostAgent.RecordDecision(agent.DecisionStart, nil)
 		}
 		// start collection only if it's not running
 		if err := p.hostAgent.StartCollector(); err != nil {
 			p.logger.Error("failed to start collector",
 				zap.Error(err))
+			p.hostAgent.RecordDecision(agent.DecisionStop, err)
 		}
 	}
 }
@@ -111,6 +171,15 @@ func getFlags(execPath string, cfg *agent.HostConfig) []cli.Flag {
 			Usage:       "Middleware API key for your account.",
 			Destination: &cfg.APIKey,
 		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "api-key-mode",
+			EnvVars: []string{"MW_API_KEY_MODE"},
+			Usage: "How the API key is sent to Middleware backend. Valid values: path, header. " +
+				"Use header only if your backend accepts the API key in the Authorization header.",
+			Destination: &cfg.APIKeyMode,
+			DefaultText: agent.APIKeyModePath,
+			Value:       agent.APIKeyModePath,
+		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:        "target",
 			EnvVars:     []string{"MW_TARGET"},
@@ -126,6 +195,48 @@ func getFlags(execPath string, cfg *agent.HostConfig) []cli.Flag {
 			DefaultText: "60s",
 			Value:       "60s",
 		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name: "config-source",
+			Usage: "Source of the otel config instead of Middleware backend: an absolute directory path or file:// URL, " +
+				"an http(s):// URL or an s3://<bucket>/<prefix> URL. The config is read from <type>.yaml " +
+				"(docker, nodocker) or config.yaml under the source.",
+			EnvVars:     []string{"MW_CONFIG_SOURCE"},
+			Destination: &cfg.ConfigSource,
+			DefaultText: agent.ConfigSourceMiddleware,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name: "config-public-key",
+			Usage: "Pinned ed25519 public key (PEM or base64) which must have signed the otel config. " +
+				"Unsigned and mis-signed configs are rejected. Empty accepts unsigned configs.",
+			EnvVars:     []string{"MW_CONFIG_PUBLIC_KEY"},
+			Destination: &cfg.ConfigPublicKey,
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name: "config-stream",
+			Usage: "Receive the config changes pushed by Middleware backend over a long-lived connection. " +
+				"Polling as per config-check-interval continues as the fallback.",
+			EnvVars:     []string{"MW_CONFIG_STREAM"},
+			Destination: &cfg.ConfigStream,
+			DefaultText: "false",
+			Value:       false,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name: "policy-file",
+			Usage: "YAML file of the local policy which constrains the otel config: allowed and forbidden " +
+				"component types, filelog include globs and maximum queue_size. Empty disables the policy.",
+			EnvVars:     []string{"MW_POLICY_FILE"},
+			Destination: &cfg.PolicyFile,
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name: "cloud-metadata",
+			Usage: "Query the AWS, GCP or Azure metadata service to identify the host by its cloud instance " +
+				"instead of its hostname. The instance is exposed to the otel config as MW_HOST_ID, " +
+				"MW_CLOUD_PROVIDER, MW_CLOUD_REGION and similar environment variables.",
+			EnvVars:     []string{"MW_CLOUD_METADATA"},
+			Destination: &cfg.CloudMetadata,
+			DefaultText: "false",
+			Value:       false,
+		}),
 		altsrc.NewBoolFlag(&cli.BoolFlag{
 			Name:        "fetch-account-otel-config",
 			EnvVars:     []string{"MW_FETCH_ACCOUNT_OTEL_CONFIG"},
@@ -188,6 +299,36 @@ func getFlags(execPath string, cfg *agent.HostConfig) []cli.Flag {
 			DefaultText: "info",
 			Value:       "info",
 		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:        "config-history-size",
+			Usage:       "Number of applied otel configs to keep for rolling back to the last known good config. Setting the value to 0 disables rollback.",
+			EnvVars:     []string{"MW_CONFIG_HISTORY_SIZE"},
+			Destination: &cfg.ConfigHistorySize,
+			DefaultText: "5",
+			Value:       5,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name: "config-rollback-window",
+			Usage: "Duration string for which the collector needs to run with a newly applied config. " +
+				"If the collector fails within this duration, the last known good config is restored.",
+			EnvVars:     []string{"MW_CONFIG_ROLLBACK_WINDOW"},
+			Destination: &cfg.ConfigRollbackWindow,
+			DefaultText: "2m",
+			Value:       "2m",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name: "offline-bundle",
+			Usage: "Directory of a signed local config bundle. If set, the agent takes the otel config from " +
+				"this bundle and never fetches it from Middleware backend.",
+			EnvVars:     []string{"MW_OFFLINE_BUNDLE"},
+			Destination: &cfg.OfflineBundle,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "offline-bundle-public-key",
+			Usage:       "ed25519 public key (PEM or base64) used to verify the signature of the offline bundle.",
+			EnvVars:     []string{"MW_OFFLINE_BUNDLE_PUBLIC_KEY"},
+			Destination: &cfg.OfflineBundlePublicKey,
+		}),
 		/* infra monitoring flag is deprecated. See log-collection flag */
 		altsrc.NewBoolFlag(&cli.BoolFlag{
 			Name:        "agent-features.infra-monitoring",
@@ -222,6 +363,41 @@ func getFlags(execPath string, cfg *agent.HostConfig) []cli.Flag {
 			DefaultText: "false",
 			Value:       false, // synthetic monitoring is disabled by default
 		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:        "agent-features.trace-collection",
+			Usage:       "Flag to enable or disable trace collection.",
+			EnvVars:     []string{"MW_AGENT_FEATURES_TRACE_COLLECTION"},
+			DefaultText: "true",
+			Value:       true,
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:        "agent-features.process-metrics",
+			Usage:       "Flag to enable or disable the process metrics of hostmetrics.",
+			EnvVars:     []string{"MW_AGENT_FEATURES_PROCESS_METRICS"},
+			DefaultText: "true",
+			Value:       true,
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:        "agent-features.container-metrics",
+			Usage:       "Flag to enable or disable container metrics (docker_stats).",
+			EnvVars:     []string{"MW_AGENT_FEATURES_CONTAINER_METRICS"},
+			DefaultText: "true",
+			Value:       true,
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:        "agent-features.journald-collection",
+			Usage:       "Flag to enable or disable journald log collection.",
+			EnvVars:     []string{"MW_AGENT_FEATURES_JOURNALD_COLLECTION"},
+			DefaultText: "true",
+			Value:       true,
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:        "agent-features.fluentforward-collection",
+			Usage:       "Flag to enable or disable fluentforward log collection.",
+			EnvVars:     []string{"MW_AGENT_FEATURES_FLUENTFORWARD_COLLECTION"},
+			DefaultText: "true",
+			Value:       true,
+		}),
 		altsrc.NewBoolFlag(&cli.BoolFlag{
 			Name:        "agent-self-profiling",
 			Usage:       "For Profiling MW Agent itself.",
@@ -266,6 +442,63 @@ func getFlags(execPath string, cfg *agent.HostConfig) []cli.Flag {
 			Value:       "8006",
 		}),
 
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "http-client.proxy-url",
+			Usage:       "Proxy URL for the calls to Middleware backend. Defaults to the HTTP_PROXY & HTTPS_PROXY environment variables.",
+			EnvVars:     []string{"MW_HTTP_CLIENT_PROXY_URL"},
+			Destination: &cfg.HTTPClient.ProxyURL,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "http-client.no-proxy",
+			Usage:       "Comma separated hosts which are called without the proxy. Defaults to the NO_PROXY environment variable.",
+			EnvVars:     []string{"MW_HTTP_CLIENT_NO_PROXY"},
+			Destination: &cfg.HTTPClient.NoProxy,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "http-client.ca-file",
+			Usage:       "PEM CA bundle to trust, in addition to the system CAs, for the calls to Middleware backend.",
+			EnvVars:     []string{"MW_HTTP_CLIENT_CA_FILE"},
+			Destination: &cfg.HTTPClient.CAFile,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "http-client.cert-file",
+			Usage:       "PEM client certificate for mTLS with Middleware backend. Requires http-client.key-file.",
+			EnvVars:     []string{"MW_HTTP_CLIENT_CERT_FILE"},
+			Destination: &cfg.HTTPClient.ClientCertFile,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "http-client.key-file",
+			Usage:       "PEM client key for mTLS with Middleware backend. Requires http-client.cert-file.",
+			EnvVars:     []string{"MW_HTTP_CLIENT_KEY_FILE"},
+			Destination: &cfg.HTTPClient.ClientKeyFile,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "http-client.tls-min-version",
+			Usage:       "Minimum TLS version for the calls to Middleware backend. Valid values: 1.0, 1.1, 1.2, 1.3.",
+			EnvVars:     []string{"MW_HTTP_CLIENT_TLS_MIN_VERSION"},
+			Destination: &cfg.HTTPClient.TLSMinVersion,
+			DefaultText: "1.2",
+			Value:       "1.2",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "http-client.timeout",
+			Usage:       "Duration string for the timeout of every call to Middleware backend.",
+			EnvVars:     []string{"MW_HTTP_CLIENT_TIMEOUT"},
+			Destination: &cfg.HTTPClient.Timeout,
+			DefaultText: "10s",
+			Value:       "10s",
+		}),
+
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name: "status-address",
+			Usage: "Loopback host:port or unix:// socket path on which the agent serves its status, " +
+				"effective config and restart decisions. Setting the value to empty disables the status server.",
+			EnvVars:     []string{"MW_STATUS_ADDRESS"},
+			Destination: &cfg.StatusAddress,
+			DefaultText: agent.DefaultStatusAddress,
+			Value:       agent.DefaultStatusAddress,
+		}),
+
 		altsrc.NewUintFlag(&cli.UintFlag{
 			Name:        "agent-internal-metrics-port",
 			Usage:       "Port where mw-agent will expose its Prometheus metrics.",
@@ -325,20 +558,77 @@ func getFlags(execPath string, cfg *agent.HostConfig) []cli.Flag {
 	}
 }
 
-func detectInfraPlatform() agent.InfraPlatform {
-	awsEnv := os.Getenv("AWS_EXECUTION_ENV")
-	if awsEnv == "AWS_ECS_EC2" {
-		return agent.InfraPlatformECSEC2
-	} else if awsEnv == "AWS_ECS_FARGATE" {
-		return agent.InfraPlatformECSFargate
+// loadFlags returns the cli.BeforeFunc which loads the flags from the
+// config file. It also sets the agent features which are kept inverted in
+// agent.AgentFeatures, as their flags have no destination.
+func loadFlags(flags []cli.Flag, cfg *agent.HostConfig) cli.BeforeFunc {
+	loadConfigFile := altsrc.InitInputSourceWithContext(flags,
+		altsrc.NewYamlSourceFromFlagFunc("config-file"))
+	return func(c *cli.Context) error {
+		if err := loadConfigFile(c); err != nil {
+			return err
+		}
+
+		features := &cfg.AgentFeatures
+		features.DisableTraceCollection = !c.Bool("agent-features.trace-collection")
+		features.DisableProcessMetrics = !c.Bool("agent-features.process-metrics")
+		features.DisableContainerMetrics = !c.Bool("agent-features.container-metrics")
+		features.DisableJournaldCollection = !c.Bool("agent-features.journald-collection")
+		features.DisableFluentforwardCollection = !c.Bool("agent-features.fluentforward-collection")
+		return nil
+	}
+}
+
+// cloudInstanceOptions returns the options which identify the host by its
+// cloud instance if enabled. The instance is also exposed to the otel
+// config through the environment. The hostname is used if the metadata
+// service can't be reached.
+func cloudInstanceOptions(ctx context.Context, cfg agent.HostConfig,
+	logger *zap.Logger) []agent.HostOptions {
+	if !cfg.CloudMetadata {
+		return nil
 	}
 
-	cycleIOEnv := os.Getenv("CYCLE_INSTANCE_ID")
-	if cycleIOEnv != "" {
-		return agent.InfraPlatformCycleIO
+	instance, err := agent.FetchCloudInstance(ctx)
+	if err != nil {
+		logger.Warn("failed to fetch cloud instance metadata, using hostname as host id",
+			zap.Error(err))
+		return nil
+	}
+
+	logger.Info("fetched cloud instance metadata", zap.String("host-id", instance.HostID()))
+	for key, value := range instance.Env() {
+		os.Setenv(key, value)
+	}
+	return []agent.HostOptions{agent.WithHostAgentCloudInstance(instance)}
+}
+
+// setConfigEnv sets the environment variables used in the otel config files
+// so that envprovider can fill those in.
+func setConfigEnv(cfg agent.HostConfig) error {
+	u, err := url.Parse(cfg.Target)
+	if err != nil {
+		return err
+	}
+
+	target := u.String()
+	if u.Port() == "" {
+		target += ":443"
 	}
 
-	return agent.InfraPlatformInstance
+	os.Setenv("MW_TARGET", target)
+	os.Setenv("MW_API_KEY", cfg.APIKey)
+	os.Setenv("MW_AGENT_GRPC_PORT", cfg.GRPCPort)
+	os.Setenv("MW_AGENT_HTTP_PORT", cfg.HTTPPort)
+	os.Setenv("MW_AGENT_FLUENT_PORT", cfg.FluentPort)
+	os.Setenv("MW_AGENT_INTERNAL_METRICS_PORT", strconv.Itoa(int(cfg.InternalMetricsPort)))
+
+	// TODO: check if on Windows, socket scheme is different than "unix"
+	os.Setenv("MW_DOCKER_ENDPOINT", cfg.DockerEndpoint)
+
+	// Setting MW_HOST_TAGS so that envprovider can fill those in the otel config files
+	os.Setenv("MW_HOST_TAGS", cfg.HostTags)
+	return nil
 }
 
 func main() {
@@ -374,6 +664,12 @@ func main() {
 	var cfg agent.HostConfig
 	flags := getFlags(execPath, &cfg)
 
+	// flags of the validate-config command
+	var integrationsDir, infraPlatformName string
+	// flags of the render-config command
+	var apiResponseFile, configType string
+	var expandEnv, showDiff bool
+
 	app := &cli.App{
 		Name:  "mw-agent",
 		Usage: "Middleware host agent",
@@ -382,7 +678,7 @@ func main() {
 				Name:   "start",
 				Usage:  "Start Middleware host agent",
 				Flags:  flags,
-				Before: altsrc.InitInputSourceWithContext(flags, altsrc.NewYamlSourceFromFlagFunc("config-file")),
+				Before: loadFlags(flags, &cfg),
 				Action: func(c *cli.Context) error {
 					loggingLevel, err := zap.ParseAtomicLevel(cfg.LoggingLevel)
 					if err != nil {
@@ -407,11 +703,12 @@ func main() {
 
 					}
 
-					zapCore = zapcore.NewCore(
+					// mask the API key & other secrets in all the agent logs
+					zapCore = agent.NewRedactingCore(zapcore.NewCore(
 						zapcore.NewJSONEncoder(zapEncoderCfg),
 						w,
 						loggingLevel.Level(),
-					)
+					), cfg.Secrets()...)
 
 					logger := zap.New(zapCore, zap.AddCaller())
 
@@ -425,7 +722,7 @@ func main() {
 						go profiler.StartProfiling("mw-host-agent", cfg.Target, cfg.HostTags)
 					}
 
-					infraPlatform := detectInfraPlatform()
+					infraPlatform := agent.DetectInfraPlatform()
 
 					hostname, err := os.Hostname()
 					if err != nil {
@@ -469,29 +766,10 @@ func main() {
 							zap.String("api-url-for-synthetic-monitoring", cfg.APIURLForSyntheticMonitoring))
 					}
 
-					u, err := url.Parse(cfg.Target)
-					if err != nil {
+					if err := setConfigEnv(cfg); err != nil {
 						return err
 					}
 
-					target := u.String()
-					if u.Port() == "" {
-						target += ":443"
-					}
-
-					// Set environment variables so that envprovider can fill those in the otel config files
-					os.Setenv("MW_TARGET", target)
-					os.Setenv("MW_API_KEY", cfg.APIKey)
-					os.Setenv("MW_AGENT_GRPC_PORT", cfg.GRPCPort)
-					os.Setenv("MW_AGENT_HTTP_PORT", cfg.HTTPPort)
-					os.Setenv("MW_AGENT_FLUENT_PORT", cfg.FluentPort)
-					os.Setenv("MW_AGENT_INTERNAL_METRICS_PORT", strconv.Itoa(int(cfg.InternalMetricsPort)))
-
-					// TODO: check if on Windows, socket scheme is different than "unix"
-					os.Setenv("MW_DOCKER_ENDPOINT", cfg.DockerEndpoint)
-
-					// Setting MW_HOST_TAGS so that envprovider can fill those in the otel config files
-					os.Setenv("MW_HOST_TAGS", cfg.HostTags)
 					// Checking if host agent has valid tags
 					if err := agent.HasValidTags(cfg.HostTags); err != nil {
 						logger.Info("host agent has invalid tags", zap.Error(err))
@@ -499,16 +777,29 @@ func main() {
 					}
 					// create hostAgent
 
+					// the agent id survives renames of the host, unlike its hostname
+					agentID, firstStart, err := agent.LoadOrCreateAgentID(filepath.Dir(cfg.OtelConfigFile))
+					if err != nil {
+						logger.Warn("failed to load agent id", zap.Error(err))
+					}
+
 					hostAgent, err := agent.NewHostAgent(
 						cfg, zapCore,
-						agent.WithHostAgentVersion(agentVersion),
-						agent.WithHostAgentInfraPlatform(infraPlatform),
+						append(cloudInstanceOptions(c.Context, cfg, logger),
+							agent.WithHostAgentVersion(agentVersion),
+							agent.WithHostAgentInfraPlatform(infraPlatform),
+							agent.WithHostAgentID(agentID),
+						)...,
 					)
 
 					if err != nil {
 						return err
 					}
 
+					if firstStart {
+						hostAgent.TrackEventAsync(agent.TrackEventInstalled, nil)
+					}
+
 					ctx, cancel := context.WithCancel(c.Context)
 					defer cancel()
 
@@ -551,9 +842,10 @@ func main() {
 
 					programWG := &sync.WaitGroup{}
 					// errCh is used to control whether the agent should collect telemetry data or not.
-					// if any of the module returns error, the agent should not collect telemetry data.
-					// For example, if the agent is not able to connect to the target,
-					// it should not collect telemetry data until it can connect to the target again.
+					// if any of the module returns error, the agent should not collect telemetry data,
+					// unless the error is one that keeps the collector in its current state.
+					// For example, if the agent is not able to connect to the target temporarily,
+					// it keeps collecting telemetry data with its current config.
 					errCh := make(chan error)
 
 					// stopCh is used to stop the go routine that can send errors to errCh
@@ -568,6 +860,18 @@ func main() {
 						args:      os.Args,
 					}
 
+					// the status server is optional, e.g. its port may be taken
+					// by another service
+					if cfg.StatusAddress != "" {
+						statusServer, err := agent.NewStatusServer(hostAgent, cfg.StatusAddress)
+						if err != nil {
+							logger.Warn("failed to start status server, continuing without it",
+								zap.String("status-address", cfg.StatusAddress), zap.Error(err))
+						} else {
+							prg.statusServer = statusServer
+						}
+					}
+
 					s, err := service.New(prg, svcConfig)
 					if err != nil {
 						logger.Fatal("could not create OS service", zap.Error(err))
@@ -581,6 +885,217 @@ func main() {
 					return nil
 				},
 			},
+			{
+				Name:  "identity",
+				Usage: "Manage the persistent agent id which identifies this host in Middleware backend",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "show",
+						Usage:  "Print the agent id",
+						Flags:  flags,
+						Before: loadFlags(flags, &cfg),
+						Action: func(c *cli.Context) error {
+							agentID, err := agent.ReadAgentID(filepath.Dir(cfg.OtelConfigFile))
+							if errors.Is(err, os.ErrNotExist) {
+								return cli.Exit("no agent id yet. It is generated on the first start of the agent.", 1)
+							}
+							if err != nil {
+								return cli.Exit(err.Error(), 1)
+							}
+
+							fmt.Println(agentID)
+							return nil
+						},
+					},
+					{
+						Name: "reset",
+						Usage: "Remove the agent id so that a new one is generated on the next start, " +
+							"e.g. before capturing a golden image",
+						Flags:  flags,
+						Before: loadFlags(flags, &cfg),
+						Action: func(c *cli.Context) error {
+							stateDir := filepath.Dir(cfg.OtelConfigFile)
+							if err := agent.ResetAgentID(stateDir); err != nil {
+								return cli.Exit(fmt.Sprintf("could not reset agent id: %v", err), 1)
+							}
+
+							fmt.Printf("removed %s, a new agent id is generated on the next start\n",
+								agent.AgentIDFile(stateDir))
+							return nil
+						},
+					},
+				},
+			},
+			{
+				Name:   "status",
+				Usage:  "Print the status of the running Middleware host agent",
+				Flags:  flags,
+				Before: loadFlags(flags, &cfg),
+				Action: func(c *cli.Context) error {
+					if cfg.StatusAddress == "" {
+						return cli.Exit("status server is disabled. Set status-address to enable it.", 1)
+					}
+
+					if err := printStatus(c.Context, os.Stdout, agent.NewStatusClient(cfg.StatusAddress)); err != nil {
+						return cli.Exit(fmt.Sprintf("could not get agent status: %v", err), 1)
+					}
+					return nil
+				},
+			},
+			{
+				Name:   "diagnose",
+				Usage:  "Check that Middleware host agent can run with its config on this host",
+				Flags:  flags,
+				Before: loadFlags(flags, &cfg),
+				Action: func(c *cli.Context) error {
+					hostAgent, err := agent.NewHostAgent(
+						cfg, zapcore.NewNopCore(),
+						agent.WithHostAgentVersion(agentVersion),
+						agent.WithHostAgentInfraPlatform(agent.DetectInfraPlatform()),
+					)
+					if err != nil {
+						return cli.Exit(fmt.Sprintf("invalid agent config: %v", err), 1)
+					}
+
+					results := hostAgent.Diagnose(c.Context)
+					printDiagnostics(os.Stdout, results)
+					if agent.DiagnosticsFailed(results) {
+						return cli.Exit("diagnose failed", 1)
+					}
+					return nil
+				},
+			},
+			{
+				Name:      "validate-config",
+				Usage:     "Validate an otel config file the way the agent validates the config from Middleware backend",
+				ArgsUsage: "<otel-config-file>",
+				Flags: append(flags,
+					&cli.StringFlag{
+						Name:        "integrations-dir",
+						Usage:       "Directory with the integration receiver configs to merge, e.g. postgresql.yaml, redis.yaml.",
+						Destination: &integrationsDir,
+					},
+					&cli.StringFlag{
+						Name:        "infra-platform",
+						Usage:       "Infrastructure platform to render the config for. Valid values: instance, ecsec2, ecsfargate, cycleio, eks, gke, aks, nomad, docker, systemdnspawn, lxc. Defaults to the detected platform.",
+						Destination: &infraPlatformName,
+					},
+				),
+				Before: loadFlags(flags, &cfg),
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 1 {
+						return cli.Exit("usage: mw-agent validate-config [flags] <otel-config-file>", 1)
+					}
+
+					infraPlatform := agent.DetectInfraPlatform()
+					if infraPlatformName != "" {
+						infraPlatform, err = agent.ParseInfraPlatform(infraPlatformName)
+						if err != nil {
+							return cli.Exit(err.Error(), 1)
+						}
+					}
+
+					hostAgent, err := agent.NewHostAgent(
+						cfg, zapcore.NewNopCore(),
+						agent.WithHostAgentVersion(agentVersion),
+						agent.WithHostAgentInfraPlatform(infraPlatform),
+					)
+					if err != nil {
+						return cli.Exit(fmt.Sprintf("invalid agent config: %v", err), 1)
+					}
+
+					errs := hostAgent.ValidateConfigFile(c.Args().First(), integrationsDir)
+					for _, err := range errs {
+						fmt.Println(err)
+					}
+					if len(errs) > 0 {
+						return cli.Exit(fmt.Sprintf("%s has %d error(s)", c.Args().First(), len(errs)), 1)
+					}
+
+					fmt.Printf("%s is valid for infra platform %s\n", c.Args().First(), infraPlatform)
+					return nil
+				},
+			},
+			{
+				Name:  "render-config",
+				Usage: "Print the otel config the agent would apply, without writing it or restarting the collector",
+				Flags: append(flags,
+					&cli.StringFlag{
+						Name:        "api-response",
+						Usage:       "Saved response of the Middleware configuration api to render. If not set, the config is fetched from Middleware backend.",
+						Destination: &apiResponseFile,
+					},
+					&cli.StringFlag{
+						Name:        "config-type",
+						Usage:       "Config to render. Valid values: docker, nodocker. Defaults to docker if docker-endpoint is a socket.",
+						Destination: &configType,
+					},
+					&cli.BoolFlag{
+						Name:        "expand-env",
+						Usage:       "Replace the ${env:...} placeholders with their values. Secrets are redacted.",
+						Destination: &expandEnv,
+					},
+					&cli.BoolFlag{
+						Name:        "diff",
+						Usage:       "Also print the diff from otel-config-file to the rendered config.",
+						Destination: &showDiff,
+					},
+				),
+				Before: loadFlags(flags, &cfg),
+				Action: func(c *cli.Context) error {
+					if apiResponseFile == "" && cfg.APIURLForConfigCheck == "" {
+						cfg.APIURLForConfigCheck, err = agent.GetAPIURLForConfigCheck(cfg.Target)
+						if err != nil {
+							return cli.Exit(fmt.Sprintf("could not derive api url from target %s: %v", cfg.Target, err), 1)
+						}
+					}
+
+					if err := setConfigEnv(cfg); err != nil {
+						return cli.Exit(fmt.Sprintf("invalid target %s: %v", cfg.Target, err), 1)
+					}
+
+					// the agent id is not created, so that rendering has no side effects
+					agentID, _ := agent.ReadAgentID(filepath.Dir(cfg.OtelConfigFile))
+
+					hostAgent, err := agent.NewHostAgent(
+						cfg, zapcore.NewNopCore(),
+						append(cloudInstanceOptions(c.Context, cfg, zap.NewNop()),
+							agent.WithHostAgentVersion(agentVersion),
+							agent.WithHostAgentInfraPlatform(agent.DetectInfraPlatform()),
+							agent.WithHostAgentID(agentID),
+						)...,
+					)
+					if err != nil {
+						return cli.Exit(fmt.Sprintf("invalid agent config: %v", err), 1)
+					}
+
+					config, err := hostAgent.RenderConfig(c.Context, apiResponseFile, configType)
+					if err != nil {
+						return cli.Exit(fmt.Sprintf("could not render config: %v", err), 1)
+					}
+
+					output := config
+					if expandEnv {
+						output, err = hostAgent.ExpandConfigEnv(config)
+						if err != nil {
+							return cli.Exit(err.Error(), 1)
+						}
+					}
+					fmt.Print(string(output))
+
+					if showDiff {
+						diff, err := hostAgent.DiffRunningConfig(config)
+						if err != nil {
+							return cli.Exit(fmt.Sprintf("could not diff config: %v", err), 1)
+						}
+						if diff == "" {
+							diff = fmt.Sprintf("no changes from %s\n", cfg.OtelConfigFile)
+						}
+						fmt.Print("\n" + diff)
+					}
+					return nil
+				},
+			},
 			{
 				Name:  "version",
 				Usage: "Returns the current agent version",
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/middleware-labs/mw-agent/pkg/agent"
+)
+
+// printStatus prints the status of the running agent queried through the
+// given status client.
+func printStatus(ctx context.Context, out io.Writer, client *agent.StatusClient) error {
+	status, err := client.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	config, err := client.Config(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Version:\t%s\n", status.Version)
+	fmt.Fprintf(w, "Infra platform:\t%s\n", status.InfraPlatform)
+	fmt.Fprintf(w, "Collector:\t%s\n", status.CollectorState)
+	fmt.Fprintf(w, "Config file:\t%s\n", status.ConfigFile)
+	fmt.Fprintf(w, "Config age:\t%s\n", age(status.ConfigLoadedAt))
+	fmt.Fprintf(w, "Last config fetch:\t%s\n", age(status.LastConfigFetch))
+	if status.LastFetchError != "" {
+		fmt.Fprintf(w, "Last fetch error:\t%s\n", status.LastFetchError)
+	}
+	if status.LastError != "" {
+		fmt.Fprintf(w, "Last error:\t%s\n", status.LastError)
+	}
+	if status.OfflineBundle != "" {
+		fmt.Fprintf(w, "Offline bundle:\t%s\n", status.OfflineBundle)
+	}
+	fmt.Fprintf(w, "Integrations:\t%s\n", listOrNone(status.Integrations))
+	if change := status.LastConfigChange; change != nil {
+		fmt.Fprintf(w, "Last config change:\t%s\n", age(change.Time))
+		for _, line := range change.Diff.Summary() {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	}
+	if len(status.PolicyViolations) > 0 {
+		fmt.Fprintln(w, "Policy violations:")
+		for _, v := range status.PolicyViolations {
+			fmt.Fprintf(w, "  %s\n", v)
+		}
+	}
+
+	if config.Error != "" {
+		fmt.Fprintf(w, "Pipelines:\t%s\n", config.Error)
+		return w.Flush()
+	}
+
+	fmt.Fprintln(w, "Pipelines:")
+	for _, p := range agent.SummarizePipelines(config.OtelConfig) {
+		fmt.Fprintf(w, "  %s\treceivers: %s\tprocessors: %s\texporters: %s\n", p.Name,
+			listOrNone(p.Receivers), listOrNone(p.Processors), listOrNone(p.Exporters))
+	}
+
+	return w.Flush()
+}
+
+// printDiagnostics prints the result of every diagnostic check.
+func printDiagnostics(out io.Writer, results []agent.CheckResult) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, r := range results {
+		fmt.Fprintf(w, "[%s]\t%s\t%s\n", strings.ToUpper(r.Result), r.Name, r.Message)
+	}
+	w.Flush()
+}
+
+func age(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return fmt.Sprintf("%s ago (%s)", time.Since(t).Round(time.Second), t.Format(time.RFC3339))
+}
+
+func listOrNone(l []string) string {
+	if len(l) == 0 {
+		return "none"
+	}
+	return strings.Join(l, ", ")
+}
@@ -32,6 +32,15 @@ func getFlags(cfg *agent.KubeConfig) []cli.Flag {
 			Usage:       "Middleware API key for your account.",
 			Destination: &cfg.APIKey,
 		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "api-key-mode",
+			EnvVars: []string{"MW_API_KEY_MODE"},
+			Usage: "How the API key is sent to Middleware backend. Valid values: path, header. " +
+				"Use header only if your backend accepts the API key in the Authorization header.",
+			Destination: &cfg.APIKeyMode,
+			DefaultText: agent.APIKeyModePath,
+			Value:       agent.APIKeyModePath,
+		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:        "target",
 			EnvVars:     []string{"MW_TARGET", "TARGET"},
@@ -135,6 +144,71 @@ func getFlags(cfg *agent.KubeConfig) []cli.Flag {
 			Value:       "8006",
 		}),
 
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "http-client.proxy-url",
+			Usage:       "Proxy URL for the calls to Middleware backend. Defaults to the HTTP_PROXY & HTTPS_PROXY environment variables.",
+			EnvVars:     []string{"MW_HTTP_CLIENT_PROXY_URL"},
+			Destination: &cfg.HTTPClient.ProxyURL,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "http-client.no-proxy",
+			Usage:       "Comma separated hosts which are called without the proxy. Defaults to the NO_PROXY environment variable.",
+			EnvVars:     []string{"MW_HTTP_CLIENT_NO_PROXY"},
+			Destination: &cfg.HTTPClient.NoProxy,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "http-client.ca-file",
+			Usage:       "PEM CA bundle to trust, in addition to the system CAs, for the calls to Middleware backend.",
+			EnvVars:     []string{"MW_HTTP_CLIENT_CA_FILE"},
+			Destination: &cfg.HTTPClient.CAFile,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "http-client.cert-file",
+			Usage:       "PEM client certificate for mTLS with Middleware backend. Requires http-client.key-file.",
+			EnvVars:     []string{"MW_HTTP_CLIENT_CERT_FILE"},
+			Destination: &cfg.HTTPClient.ClientCertFile,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "http-client.key-file",
+			Usage:       "PEM client key for mTLS with Middleware backend. Requires http-client.cert-file.",
+			EnvVars:     []string{"MW_HTTP_CLIENT_KEY_FILE"},
+			Destination: &cfg.HTTPClient.ClientKeyFile,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "http-client.tls-min-version",
+			Usage:       "Minimum TLS version for the calls to Middleware backend. Valid values: 1.0, 1.1, 1.2, 1.3.",
+			EnvVars:     []string{"MW_HTTP_CLIENT_TLS_MIN_VERSION"},
+			Destination: &cfg.HTTPClient.TLSMinVersion,
+			DefaultText: "1.2",
+			Value:       "1.2",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:        "http-client.timeout",
+			Usage:       "Duration string for the timeout of every call to Middleware backend.",
+			EnvVars:     []string{"MW_HTTP_CLIENT_TIMEOUT"},
+			Destination: &cfg.HTTPClient.Timeout,
+			DefaultText: "10s",
+			Value:       "10s",
+		}),
+
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name: "config-source",
+			Usage: "Source of the otel configs instead of Middleware backend: an absolute directory path or file:// URL, " +
+				"an http(s):// URL or an s3://<bucket>/<prefix> URL. The configs are read from <type>.yaml " +
+				"(deployment, daemonset) or config.yaml under the source.",
+			EnvVars:     []string{"MW_CONFIG_SOURCE"},
+			Destination: &cfg.ConfigSource,
+			DefaultText: agent.ConfigSourceMiddleware,
+		}),
+
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name: "config-public-key",
+			Usage: "Pinned ed25519 public key (PEM or base64) which must have signed the otel configs. " +
+				"Unsigned and mis-signed configs are rejected. Empty accepts unsigned configs.",
+			EnvVars:     []string{"MW_CONFIG_PUBLIC_KEY"},
+			Destination: &cfg.ConfigPublicKey,
+		}),
+
 		altsrc.NewUintFlag(&cli.UintFlag{
 			Name:        "agent-internal-metrics-port",
 			Usage:       "Port where mw-agent will expose its Prometheus metrics.",
@@ -168,6 +242,13 @@ func getFlags(cfg *agent.KubeConfig) []cli.Flag {
 	}
 }
 
+// redactSecrets masks the API key & other secrets in the logs
+func redactSecrets(cfg agent.KubeConfig) zap.Option {
+	return zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return agent.NewRedactingCore(core, cfg.Secrets()...)
+	})
+}
+
 func main() {
 	var cfg agent.KubeConfig
 	flags := getFlags(&cfg)
@@ -200,6 +281,8 @@ func main() {
 				Usage: "Start Middleware Kubernetes agent",
 				Flags: flags,
 				Action: func(c *cli.Context) error {
+					logger := logger.WithOptions(redactSecrets(cfg))
+
 					if cfg.SelfProfiling {
 						profiler := agent.NewProfiler(logger, cfg.ProfilngServerURL)
 						// start profiling
@@ -250,7 +333,7 @@ func main() {
 
 					settings := otelcol.CollectorSettings{
 						DisableGracefulShutdown: true,
-						LoggingOptions:          []zap.Option{},
+						LoggingOptions:          []zap.Option{redactSecrets(cfg)},
 						BuildInfo: component.BuildInfo{
 							Command:     "otelcontribcol",
 							Description: "OpenTelemetry Collector Contrib",
@@ -272,6 +355,7 @@ func main() {
 				Usage: "Watch for configuration updates and restart the agent when a change is detected",
 				Flags: flags,
 				Action: func(c *cli.Context) error {
+					logger := logger.WithOptions(redactSecrets(cfg))
 
 					if cfg.APIURLForConfigCheck == "" {
 						var err error
@@ -295,7 +379,26 @@ func main() {
 						mwNamespace = "mw-agent-ns"
 					}
 
+					if err := cfg.ValidateAPIKeyMode(); err != nil {
+						logger.Error("invalid api key mode", zap.Error(err))
+						return err
+					}
+
+					httpClient, err := agent.NewHTTPClient(cfg.HTTPClient)
+					if err != nil {
+						logger.Error("invalid http client config", zap.Error(err))
+						return err
+					}
+
+					configSource, err := agent.NewConfigSource(cfg.ConfigSource, httpClient.Do)
+					if err != nil {
+						logger.Error("invalid config source", zap.Error(err))
+						return err
+					}
+
 					kubeAgentMonitor := agent.NewKubeAgentMonitor(cfg,
+						agent.WithKubeAgentMonitorHTTPClient(httpClient),
+						agent.WithKubeAgentMonitorConfigSource(configSource),
 						agent.WithKubeAgentMonitorClusterName(os.Getenv("MW_KUBE_CLUSTER_NAME")),
 						agent.WithKubeAgentMonitorAgentNamespace(mwNamespace),
 						agent.WithKubeAgentMonitorDaemonset("mw-kube-agent"),
@@ -305,7 +408,7 @@ func main() {
 						agent.WithKubeAgentMonitorVersion(agentVersion),
 					)
 
-					err := kubeAgentMonitor.SetClientSet()
+					err = kubeAgentMonitor.SetClientSet()
 					if err != nil {
 						logger.Error("collector server run finished with error", zap.Error(err))
 						return err
@@ -325,6 +428,7 @@ func main() {
 				Usage: "Update the configmaps as per Server settings",
 				Flags: flags,
 				Action: func(c *cli.Context) error {
+					logger := logger.WithOptions(redactSecrets(cfg))
 
 					ctx, cancel := context.WithCancel(c.Context)
 					defer func() {
@@ -336,7 +440,26 @@ func main() {
 						mwNamespace = "mw-agent-ns"
 					}
 
+					if err := cfg.ValidateAPIKeyMode(); err != nil {
+						logger.Error("invalid api key mode", zap.Error(err))
+						return err
+					}
+
+					httpClient, err := agent.NewHTTPClient(cfg.HTTPClient)
+					if err != nil {
+						logger.Error("invalid http client config", zap.Error(err))
+						return err
+					}
+
+					configSource, err := agent.NewConfigSource(cfg.ConfigSource, httpClient.Do)
+					if err != nil {
+						logger.Error("invalid config source", zap.Error(err))
+						return err
+					}
+
 					kubeAgentMonitor := agent.NewKubeAgentMonitor(cfg,
+						agent.WithKubeAgentMonitorHTTPClient(httpClient),
+						agent.WithKubeAgentMonitorConfigSource(configSource),
 						agent.WithKubeAgentMonitorClusterName(os.Getenv("MW_KUBE_CLUSTER_NAME")),
 						agent.WithKubeAgentMonitorAgentNamespace(mwNamespace),
 						agent.WithKubeAgentMonitorDaemonset("mw-kube-agent"),
@@ -346,7 +469,7 @@ func main() {
 						agent.WithKubeAgentMonitorVersion(agentVersion),
 					)
 
-					err := kubeAgentMonitor.SetClientSet()
+					err = kubeAgentMonitor.SetClientSet()
 					if err != nil {
 						logger.Error("collector server run finished with error", zap.Error(err))
 						return err
@@ -0,0 +1,79 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// API key modes define how the API key is sent to the Middleware backend
+const (
+	// APIKeyModeHeader sends the API key in the Authorization header. It
+	// must be opted into, as older backends only accept the API key in the
+	// URL path.
+	APIKeyModeHeader = "header"
+	// APIKeyModePath sends the API key as the last element of the URL
+	// path. It is the default.
+	APIKeyModePath = "path"
+)
+
+var ErrInvalidAPIKeyMode = errors.New("invalid api key mode")
+
+// ValidateAPIKeyMode checks that the API key mode is one of the supported
+// modes. An empty mode means APIKeyModePath.
+func (c BaseConfig) ValidateAPIKeyMode() error {
+	switch c.APIKeyMode {
+	case "", APIKeyModeHeader, APIKeyModePath:
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrInvalidAPIKeyMode, c.APIKeyMode)
+}
+
+// apiURL returns the URL of the given backend api. Unless the API key is
+// sent in the Authorization header, it is added to the URL path.
+func (c BaseConfig) apiURL(apiPath string) (*url.URL, error) {
+	u, err := url.Parse(c.APIURLForConfigCheck)
+	if err != nil {
+		return nil, err
+	}
+
+	u = u.JoinPath(apiPath)
+	if c.APIKeyMode != APIKeyModeHeader {
+		u = u.JoinPath(c.APIKey)
+	}
+	return u, nil
+}
+
+// setAPIKey adds the API key to the Authorization header of req in
+// APIKeyModeHeader. Otherwise the API key is already in the URL path.
+func (c BaseConfig) setAPIKey(req *http.Request) {
+	if c.APIKeyMode != APIKeyModeHeader {
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+c.APIKey)
+}
+
+// redactURL returns rawURL without the API key and the user credentials so
+// that it can be logged.
+func (c BaseConfig) redactURL(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil {
+		rawURL = u.Redacted()
+	}
+
+	if c.APIKey == "" {
+		return rawURL
+	}
+	return strings.ReplaceAll(rawURL, c.APIKey, redactedSecret)
+}
+
+// redactError removes the API key from the URL carried by the errors
+// returned by http.Client.
+func (c BaseConfig) redactError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		urlErr.URL = c.redactURL(urlErr.URL)
+	}
+	return err
+}
@@ -0,0 +1,65 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const backupFileSuffix = ".bak"
+
+// writeFileAtomic writes data to path through a temporary file in the same
+// directory which is synced to disk and then renamed over path. A crash or
+// a full disk during the write leaves the previous contents of path intact.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmpFile, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file in %s: %w", dir, err)
+	}
+
+	tmpPath := tmpFile.Name()
+	// remove the temporary file if it could not be renamed over path
+	defer os.Remove(tmpPath)
+
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to write temporary file %s: %w", tmpPath, err)
+	}
+
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to sync temporary file %s: %w", tmpPath, err)
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file %s: %w", tmpPath, err)
+	}
+
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		return fmt.Errorf("failed to set permissions on temporary file %s: %w", tmpPath, err)
+	}
+
+	if err := replaceFile(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+
+	return syncDir(dir)
+}
+
+// writeFileAtomicWithBackup is like writeFileAtomic but keeps the previous
+// contents of path, if any, in path.bak.
+func writeFileAtomicWithBackup(path string, data []byte, perm os.FileMode) error {
+	oldData, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s for backup: %w", path, err)
+	}
+
+	if err == nil {
+		if err := writeFileAtomic(path+backupFileSuffix, oldData, perm); err != nil {
+			return fmt.Errorf("failed to back up %s: %w", path, err)
+		}
+	}
+
+	return writeFileAtomic(path, data, perm)
+}
@@ -0,0 +1,50 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "otel-config.yaml")
+
+	assert.NoError(t, writeFileAtomic(path, []byte("receivers: {}"), 0644))
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "receivers: {}", string(data))
+
+	// no temporary files should be left behind
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestWriteFileAtomicWithBackup(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "otel-config.yaml")
+
+	// no backup is created when there is no previous file
+	assert.NoError(t, writeFileAtomicWithBackup(path, []byte("first"), 0644))
+	_, err := os.Stat(path + backupFileSuffix)
+	assert.True(t, os.IsNotExist(err))
+
+	assert.NoError(t, writeFileAtomicWithBackup(path, []byte("second"), 0644))
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "second", string(data))
+
+	backup, err := os.ReadFile(path + backupFileSuffix)
+	assert.NoError(t, err)
+	assert.Equal(t, "first", string(backup))
+}
+
+func TestWriteFileAtomicMissingDir(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "otel-config.yaml")
+	assert.Error(t, writeFileAtomic(path, []byte("data"), 0644))
+}
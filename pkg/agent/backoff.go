@@ -0,0 +1,113 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// backoff describes how failed backend API calls are retried. The zero
+// value makes a single attempt without retries.
+type backoff struct {
+	initial     time.Duration
+	max         time.Duration
+	maxAttempts int
+}
+
+// defaultBackoff is used for the backend API calls of HostAgent.
+var defaultBackoff = backoff{
+	initial:     time.Second,
+	max:         30 * time.Second,
+	maxAttempts: 5,
+}
+
+// delay returns the time to wait before retrying after the given failed
+// attempt, starting at 1. The delay is picked at random between 0 and the
+// exponentially growing, capped delay ("full jitter") so that agents that
+// failed together don't retry together.
+func (b backoff) delay(attempt int) time.Duration {
+	d := b.initial
+	for i := 1; i < attempt && d < b.max; i++ {
+		d *= 2
+	}
+	if d > b.max {
+		d = b.max
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// isRetryableStatus checks whether a backend API response with the given
+// status code is worth retrying.
+func isRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests ||
+		statusCode >= http.StatusInternalServerError
+}
+
+// doWithRetry sends the request built by newRequest using do. Network errors
+// and retryable status codes are retried as per the backoff of the agent.
+// The API key is added to every request as per the API key mode and removed
+// from the returned errors. If all the attempts fail, the returned error
+// wraps ErrTransient.
+// The caller must close the body of the returned response.
+func (c *HostAgent) doWithRetry(ctx context.Context,
+	newRequest func(ctx context.Context) (*http.Request, error),
+	do func(req *http.Request) (*http.Response, error)) (*http.Response, error) {
+	var lastErr error
+	for attempt := 1; ; attempt++ {
+		req, err := newRequest(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+
+		c.setAPIKey(req)
+		resp, err := do(req)
+		err = c.redactError(err)
+		if err == nil && !isRetryableStatus(resp.StatusCode) {
+			return resp, nil
+		}
+
+		if err == nil {
+			resp.Body.Close()
+			err = fmt.Errorf("backend api returned status: %d", resp.StatusCode)
+		}
+		lastErr = err
+
+		if attempt >= c.backoff.maxAttempts {
+			break
+		}
+
+		delay := c.backoff.delay(attempt)
+		c.logger.Warn("backend api call failed, retrying",
+			zap.Int("attempt", attempt),
+			zap.Duration("delay", delay),
+			zap.Error(err))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("%w: %v", ErrTransient, ctx.Err())
+		case <-timer.C:
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %v", ErrTransient, lastErr)
+}
+
+// splay returns a random duration between 0 and d. Agents wait for it
+// before they first fetch the config from the backend, if they already
+// have a config to run with, and before they start polling the backend so
+// that a fleet of agents started together doesn't hit the backend at once.
+func splay(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
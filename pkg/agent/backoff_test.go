@@ -0,0 +1,104 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	b := backoff{initial: 10 * time.Millisecond, max: 50 * time.Millisecond, maxAttempts: 5}
+	for attempt := 1; attempt <= 10; attempt++ {
+		delay := b.delay(attempt)
+		assert.GreaterOrEqual(t, delay, time.Duration(0))
+		assert.LessOrEqual(t, delay, b.max)
+	}
+
+	assert.Equal(t, time.Duration(0), backoff{}.delay(1))
+}
+
+func TestDoWithRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		statusCodes  []int
+		wantStatus   int
+		wantRequests int
+		wantErr      error
+	}{
+		{
+			name:         "recovers after transient failures",
+			statusCodes:  []int{http.StatusBadGateway, http.StatusTooManyRequests, http.StatusOK},
+			wantStatus:   http.StatusOK,
+			wantRequests: 3,
+		},
+		{
+			name:         "client errors are not retried",
+			statusCodes:  []int{http.StatusBadRequest},
+			wantStatus:   http.StatusBadRequest,
+			wantRequests: 1,
+		},
+		{
+			name: "gives up after max attempts",
+			statusCodes: []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable,
+				http.StatusServiceUnavailable, http.StatusOK},
+			wantRequests: 3,
+			wantErr:      ErrTransient,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.statusCodes[requests])
+				requests++
+			}))
+			defer mockServer.Close()
+
+			agent := &HostAgent{
+				logger:  zap.NewNop(),
+				backoff: backoff{initial: time.Millisecond, max: time.Millisecond, maxAttempts: 3},
+			}
+
+			resp, err := agent.doWithRetry(context.Background(),
+				func(ctx context.Context) (*http.Request, error) {
+					return http.NewRequestWithContext(ctx, http.MethodGet, mockServer.URL, nil)
+				}, http.DefaultClient.Do)
+
+			assert.Equal(t, tt.wantRequests, requests)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+
+			assert.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+		})
+	}
+}
+
+func TestDoWithRetryCanceled(t *testing.T) {
+	agent := &HostAgent{
+		logger:  zap.NewNop(),
+		backoff: backoff{initial: time.Hour, max: time.Hour, maxAttempts: 3},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	requests := 0
+	_, err := agent.doWithRetry(ctx, func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodGet, "http://example.com", nil)
+	}, func(req *http.Request) (*http.Response, error) {
+		requests++
+		cancel()
+		return nil, context.Canceled
+	})
+
+	assert.ErrorIs(t, err, ErrTransient)
+	assert.Equal(t, 1, requests)
+}
@@ -0,0 +1,161 @@
+package agent
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// A config bundle is a directory which provides the otel config to the
+// agent without contacting Middleware backend. It has the layout
+//
+//	manifest.json                    sha256 of every file in the bundle
+//	manifest.json.sig                base64 ed25519 signature of manifest.json
+//	otel-config-docker.yaml          otel config used when docker is running
+//	otel-config-nodocker.yaml        otel config used otherwise
+//	integrations/<integration>.yaml  receiver config for an integration
+//	                                 e.g. integrations/postgresql.yaml
+//
+// Only the files listed in the manifest are used.
+const (
+	bundleManifestFile      = "manifest.json"
+	bundleSignatureFile     = "manifest.json.sig"
+	bundleIntegrationsDir   = "integrations"
+	bundleConfigFilePattern = "otel-config-%s.yaml"
+)
+
+type bundleManifest struct {
+	// Files maps the slash separated path of a file in the bundle
+	// to the hex encoded sha256 of its contents
+	Files map[string]string `json:"files"`
+}
+
+type configBundle struct {
+	// configs maps the config type (docker, nodocker) to the otel config
+	configs      map[string]map[string]interface{}
+	integrations map[IntegrationType]IntegrationConfig
+}
+
+// loadConfigBundle verifies the signature of the config bundle in dir and
+// the checksums of the files in it, and loads the bundle.
+func loadConfigBundle(dir string, pub ed25519.PublicKey) (*configBundle, error) {
+	manifestBytes, err := os.ReadFile(filepath.Join(dir, bundleManifestFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle manifest: %w", err)
+	}
+
+	signature, err := os.ReadFile(filepath.Join(dir, bundleSignatureFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle signature: %w", err)
+	}
+
+	if err := verifySignature(pub, manifestBytes, string(signature)); err != nil {
+		return nil, fmt.Errorf("%w: bundle manifest: %v", ErrInvalidConfig, err)
+	}
+
+	var manifest bundleManifest
+	if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal bundle manifest: %w", err)
+	}
+
+	bundle := &configBundle{
+		configs:      map[string]map[string]interface{}{},
+		integrations: map[IntegrationType]IntegrationConfig{},
+	}
+
+	for name, checksum := range manifest.Files {
+		cleanName := path.Clean(name)
+		if path.IsAbs(cleanName) || strings.HasPrefix(cleanName, "..") {
+			return nil, fmt.Errorf("%w: bundle file %s is outside the bundle", ErrInvalidConfig, name)
+		}
+
+		filePath := filepath.Join(dir, filepath.FromSlash(cleanName))
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bundle file %s: %w", name, err)
+		}
+
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != strings.ToLower(checksum) {
+			return nil, fmt.Errorf("%w: checksum mismatch for bundle file %s", ErrInvalidConfig, name)
+		}
+
+		for _, configType := range []string{"docker", "nodocker"} {
+			if cleanName != fmt.Sprintf(bundleConfigFilePattern, configType) {
+				continue
+			}
+
+			config, err := parseConfig(data)
+			if err != nil {
+				return nil, fmt.Errorf("%w: bundle file %s: %v", ErrInvalidConfig, name, err)
+			}
+			bundle.configs[configType] = config
+		}
+
+		for _, integrationType := range integrationTypes {
+			if cleanName == path.Join(bundleIntegrationsDir, integrationType.String()+".yaml") {
+				bundle.integrations[integrationType] = IntegrationConfig{
+					Path: filePath,
+				}
+			}
+		}
+	}
+
+	return bundle, nil
+}
+
+// ApplyOfflineBundle verifies the local config bundle and applies the otel
+// config from it without contacting Middleware backend. The config goes
+// through the same rewrites and validation as the config fetched from
+// Middleware backend.
+func (c *HostAgent) ApplyOfflineBundle() error {
+	pub, err := parseEd25519PublicKey(c.OfflineBundlePublicKey)
+	if err != nil {
+		return err
+	}
+
+	bundle, err := loadConfigBundle(c.OfflineBundle, pub)
+	if err != nil {
+		return err
+	}
+
+	configType := c.getConfigType()
+	config, ok := bundle.configs[configType]
+	if !ok {
+		return fmt.Errorf("%w: bundle %s has no %s config", ErrInvalidConfig,
+			c.OfflineBundle, fmt.Sprintf(bundleConfigFilePattern, configType))
+	}
+
+	configBytes, integrations, err := c.renderConfig(config, bundle.integrations)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := c.loadConfig(configBytes)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+
+	c.logger.Info("applying otel config from offline bundle",
+		zap.String("bundle", c.OfflineBundle),
+		zap.String("config_type", configType))
+
+	if err := c.applyConfig(configBytes); err != nil {
+		return err
+	}
+
+	c.state.setIntegrations(integrations)
+	return nil
+}
@@ -0,0 +1,167 @@
+package agent
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+)
+
+const testBundleConfig = `
+receivers:
+  otlp:
+    protocols:
+      grpc:
+        endpoint: 127.0.0.1:0
+exporters:
+  debug: {}
+service:
+  pipelines:
+    metrics:
+      receivers: [otlp]
+      exporters: [debug]
+    logs:
+      receivers: [otlp]
+      exporters: [debug]
+`
+
+// writeTestBundle writes the given files as a config bundle signed with priv
+func writeTestBundle(t *testing.T, dir string, files map[string]string, priv ed25519.PrivateKey) {
+	t.Helper()
+
+	assert.NoError(t, os.MkdirAll(dir, 0755))
+	manifest := bundleManifest{Files: map[string]string{}}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+
+		sum := sha256.Sum256([]byte(content))
+		manifest.Files[name] = hex.EncodeToString(sum[:])
+	}
+
+	manifestBytes, err := json.Marshal(manifest)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, bundleManifestFile), manifestBytes, 0644))
+
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, manifestBytes))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, bundleSignatureFile), []byte(sig), 0644))
+}
+
+func TestParseEd25519PublicKey(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	assert.NoError(t, err)
+	pemKey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{"base64 raw key", base64.StdEncoding.EncodeToString(pub), false},
+		{"PEM key", pemKey, false},
+		{"empty key", "", true},
+		{"short key", base64.StdEncoding.EncodeToString(pub[:16]), true},
+		{"not base64", "not a key", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseEd25519PublicKey(tt.key)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidPublicKey)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, pub, got)
+		})
+	}
+}
+
+func TestLoadConfigBundle(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	dir := t.TempDir()
+	writeTestBundle(t, dir, map[string]string{
+		"otel-config-nodocker.yaml":    testBundleConfig,
+		"integrations/postgresql.yaml": "postgresql:\n  endpoint: localhost:5432\n",
+	}, priv)
+
+	bundle, err := loadConfigBundle(dir, pub)
+	assert.NoError(t, err)
+	assert.Contains(t, bundle.configs, "nodocker")
+	assert.NotContains(t, bundle.configs, "docker")
+	assert.Equal(t, filepath.Join(dir, "integrations", "postgresql.yaml"),
+		bundle.integrations[PostgreSQL].Path)
+
+	// tampering with a file must be detected
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "otel-config-nodocker.yaml"),
+		[]byte("receivers: {}"), 0644))
+	_, err = loadConfigBundle(dir, pub)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+
+	// bundle signed with another key must be rejected
+	otherPub, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	_, err = loadConfigBundle(dir, otherPub)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+}
+
+func TestLoadConfigBundleOutsidePath(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	dir := t.TempDir()
+	writeTestBundle(t, filepath.Join(dir, "bundle"), map[string]string{
+		"../outside.yaml": testBundleConfig,
+	}, priv)
+
+	_, err = loadConfigBundle(filepath.Join(dir, "bundle"), pub)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+}
+
+func TestHostAgentApplyOfflineBundle(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	bundleDir := t.TempDir()
+	writeTestBundle(t, bundleDir, map[string]string{
+		"otel-config-nodocker.yaml": testBundleConfig,
+	}, priv)
+
+	otelConfigFile := filepath.Join(t.TempDir(), "otel-config.yaml")
+	agent, err := NewHostAgent(HostConfig{
+		BaseConfig: BaseConfig{
+			OtelConfigFile: otelConfigFile,
+			AgentFeatures: AgentFeatures{
+				MetricCollection: true,
+			},
+		},
+		OfflineBundle:          bundleDir,
+		OfflineBundlePublicKey: base64.StdEncoding.EncodeToString(pub),
+	}, zapcore.NewNopCore())
+	assert.NoError(t, err)
+
+	assert.NoError(t, agent.ApplyOfflineBundle())
+
+	// log collection is disabled, so the logs pipeline must be removed
+	config, err := readConfigFile(otelConfigFile)
+	assert.NoError(t, err)
+	pipelines := config[Service].(map[string]interface{})[Pipelines].(map[string]interface{})
+	assert.Contains(t, pipelines, "metrics")
+	assert.NotContains(t, pipelines, "logs")
+}
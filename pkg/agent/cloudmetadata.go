@@ -0,0 +1,237 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// cloudMetadataTimeout bounds the queries to the metadata service of
+	// each cloud
+	cloudMetadataTimeout = 2 * time.Second
+	awsIMDSTokenTTL      = "300"
+)
+
+var ErrCloudMetadataUnavailable = errors.New("cloud metadata service unavailable")
+
+// cloudMetadataEndpoints are the base URLs of the metadata services.
+type cloudMetadataEndpoints struct {
+	aws   string
+	gcp   string
+	azure string
+}
+
+var defaultCloudMetadataEndpoints = cloudMetadataEndpoints{
+	aws:   "http://169.254.169.254",
+	gcp:   "http://metadata.google.internal",
+	azure: "http://169.254.169.254",
+}
+
+// CloudInstance is the cloud VM the agent runs on as per the metadata
+// service of the cloud.
+type CloudInstance struct {
+	Provider   string
+	InstanceID string
+	Region     string
+	Zone       string
+	AccountID  string
+}
+
+// HostID returns the ID of the host which, unlike its hostname, is unique
+// and doesn't change across reboots, e.g. aws:us-east-1:us-east-1a:i-0abc.
+func (i *CloudInstance) HostID() string {
+	return strings.Join([]string{i.Provider, i.Region, i.Zone, i.InstanceID}, ":")
+}
+
+// Env returns the environment variables which expose the instance to the
+// otel config of the collector, e.g. ${env:MW_CLOUD_REGION}.
+func (i *CloudInstance) Env() map[string]string {
+	return map[string]string{
+		"MW_HOST_ID":                 i.HostID(),
+		"MW_HOST_INSTANCE_ID":        i.InstanceID,
+		"MW_CLOUD_PROVIDER":          i.Provider,
+		"MW_CLOUD_REGION":            i.Region,
+		"MW_CLOUD_AVAILABILITY_ZONE": i.Zone,
+		"MW_CLOUD_ACCOUNT_ID":        i.AccountID,
+	}
+}
+
+// FetchCloudInstance queries the metadata service of the cloud the agent
+// runs on. The cloud is detected from the DMI data of the host, and every
+// cloud is tried if it is unknown.
+func FetchCloudInstance(ctx context.Context) (*CloudInstance, error) {
+	// the metadata services are link-local, so they are never proxied
+	client := &http.Client{
+		Timeout:   cloudMetadataTimeout,
+		Transport: &http.Transport{Proxy: nil},
+	}
+	return fetchCloudInstance(ctx, client.Do, defaultCloudMetadataEndpoints,
+		cloudProvider(HostPlatformEnv()))
+}
+
+func fetchCloudInstance(ctx context.Context, do func(*http.Request) (*http.Response, error),
+	endpoints cloudMetadataEndpoints, provider string) (*CloudInstance, error) {
+	fetchers := map[string]func(context.Context) (*CloudInstance, error){
+		cloudProviderAWS: func(ctx context.Context) (*CloudInstance, error) {
+			return fetchAWSInstance(ctx, do, endpoints.aws)
+		},
+		cloudProviderGCP: func(ctx context.Context) (*CloudInstance, error) {
+			return fetchGCPInstance(ctx, do, endpoints.gcp)
+		},
+		cloudProviderAzure: func(ctx context.Context) (*CloudInstance, error) {
+			return fetchAzureInstance(ctx, do, endpoints.azure)
+		},
+	}
+
+	providers := []string{cloudProviderAWS, cloudProviderGCP, cloudProviderAzure}
+	if provider != "" {
+		providers = []string{provider}
+	}
+
+	var errs []error
+	for _, p := range providers {
+		ctx, cancel := context.WithTimeout(ctx, cloudMetadataTimeout)
+		instance, err := fetchers[p](ctx)
+		cancel()
+		if err == nil {
+			return instance, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", p, err))
+	}
+
+	return nil, fmt.Errorf("%w: %v", ErrCloudMetadataUnavailable, errors.Join(errs...))
+}
+
+// getMetadata calls the metadata service with the given request headers.
+func getMetadata(ctx context.Context, do func(*http.Request) (*http.Response, error),
+	method string, url string, headers map[string]string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned status: %d", req.URL.Path, resp.StatusCode)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// fetchAWSInstance reads the instance identity document with IMDSv2.
+func fetchAWSInstance(ctx context.Context, do func(*http.Request) (*http.Response, error),
+	endpoint string) (*CloudInstance, error) {
+	token, err := getMetadata(ctx, do, http.MethodPut, endpoint+"/latest/api/token",
+		map[string]string{"X-aws-ec2-metadata-token-ttl-seconds": awsIMDSTokenTTL})
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := getMetadata(ctx, do, http.MethodGet,
+		endpoint+"/latest/dynamic/instance-identity/document",
+		map[string]string{"X-aws-ec2-metadata-token": string(token)})
+	if err != nil {
+		return nil, err
+	}
+
+	var document struct {
+		InstanceID       string `json:"instanceId"`
+		Region           string `json:"region"`
+		AvailabilityZone string `json:"availabilityZone"`
+		AccountID        string `json:"accountId"`
+	}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+
+	return newCloudInstance(cloudProviderAWS, document.InstanceID, document.Region,
+		document.AvailabilityZone, document.AccountID)
+}
+
+// fetchGCPInstance reads the instance id, zone and project of the VM.
+func fetchGCPInstance(ctx context.Context, do func(*http.Request) (*http.Response, error),
+	endpoint string) (*CloudInstance, error) {
+	get := func(path string) (string, error) {
+		data, err := getMetadata(ctx, do, http.MethodGet, endpoint+"/computeMetadata/v1/"+path,
+			map[string]string{"Metadata-Flavor": "Google"})
+		return strings.TrimSpace(string(data)), err
+	}
+
+	instanceID, err := get("instance/id")
+	if err != nil {
+		return nil, err
+	}
+
+	// projects/<project number>/zones/<zone>
+	zone, err := get("instance/zone")
+	if err != nil {
+		return nil, err
+	}
+	zone = zone[strings.LastIndex(zone, "/")+1:]
+
+	projectID, err := get("project/project-id")
+	if err != nil {
+		return nil, err
+	}
+
+	// the region is the zone without its suffix, e.g. us-central1 for
+	// us-central1-a
+	region := zone
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		region = zone[:i]
+	}
+
+	return newCloudInstance(cloudProviderGCP, instanceID, region, zone, projectID)
+}
+
+// fetchAzureInstance reads the compute metadata of the VM.
+func fetchAzureInstance(ctx context.Context, do func(*http.Request) (*http.Response, error),
+	endpoint string) (*CloudInstance, error) {
+	data, err := getMetadata(ctx, do, http.MethodGet,
+		endpoint+"/metadata/instance/compute?api-version=2021-02-01&format=json",
+		map[string]string{"Metadata": "true"})
+	if err != nil {
+		return nil, err
+	}
+
+	var compute struct {
+		VMID           string `json:"vmId"`
+		Location       string `json:"location"`
+		Zone           string `json:"zone"`
+		SubscriptionID string `json:"subscriptionId"`
+	}
+	if err := json.Unmarshal(data, &compute); err != nil {
+		return nil, err
+	}
+
+	return newCloudInstance(cloudProviderAzure, compute.VMID, compute.Location,
+		compute.Zone, compute.SubscriptionID)
+}
+
+func newCloudInstance(provider, instanceID, region, zone, accountID string) (*CloudInstance, error) {
+	if instanceID == "" {
+		return nil, errors.New("metadata has no instance id")
+	}
+
+	return &CloudInstance{
+		Provider:   provider,
+		InstanceID: instanceID,
+		Region:     region,
+		Zone:       zone,
+		AccountID:  accountID,
+	}, nil
+}
@@ -0,0 +1,157 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+func newAWSMetadataServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/latest/api/token":
+			assert.Equal(t, awsIMDSTokenTTL, r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds"))
+			_, _ = w.Write([]byte("token"))
+		case r.URL.Path == "/latest/dynamic/instance-identity/document":
+			// IMDSv2 requires the token
+			if r.Header.Get("X-aws-ec2-metadata-token") != "token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, _ = w.Write([]byte(`{"instanceId": "i-0abc", "region": "us-east-1",
+				"availabilityZone": "us-east-1a", "accountId": "111122223333"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestFetchCloudInstance(t *testing.T) {
+	awsServer := newAWSMetadataServer(t)
+
+	gcpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/computeMetadata/v1/instance/id":
+			_, _ = w.Write([]byte("4520031799277581759"))
+		case "/computeMetadata/v1/instance/zone":
+			_, _ = w.Write([]byte("projects/123456/zones/us-central1-a"))
+		case "/computeMetadata/v1/project/project-id":
+			_, _ = w.Write([]byte("my-project"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer gcpServer.Close()
+
+	azureServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata") != "true" || r.URL.Path != "/metadata/instance/compute" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"vmId": "02aab8a4-74ef-476e-8182-f6d2ba4166a6", "location": "westeurope",
+			"zone": "1", "subscriptionId": "8d10da13"}`))
+	}))
+	defer azureServer.Close()
+
+	unavailable := httptest.NewServer(http.NotFoundHandler())
+	defer unavailable.Close()
+
+	tests := []struct {
+		name      string
+		endpoints cloudMetadataEndpoints
+		provider  string
+		want      CloudInstance
+		wantID    string
+	}{
+		{
+			name:      "aws",
+			endpoints: cloudMetadataEndpoints{aws: awsServer.URL},
+			provider:  cloudProviderAWS,
+			want: CloudInstance{Provider: "aws", InstanceID: "i-0abc", Region: "us-east-1",
+				Zone: "us-east-1a", AccountID: "111122223333"},
+			wantID: "aws:us-east-1:us-east-1a:i-0abc",
+		},
+		{
+			name:      "gcp",
+			endpoints: cloudMetadataEndpoints{gcp: gcpServer.URL},
+			provider:  cloudProviderGCP,
+			want: CloudInstance{Provider: "gcp", InstanceID: "4520031799277581759", Region: "us-central1",
+				Zone: "us-central1-a", AccountID: "my-project"},
+			wantID: "gcp:us-central1:us-central1-a:4520031799277581759",
+		},
+		{
+			name:      "azure",
+			endpoints: cloudMetadataEndpoints{azure: azureServer.URL},
+			provider:  cloudProviderAzure,
+			want: CloudInstance{Provider: "azure", InstanceID: "02aab8a4-74ef-476e-8182-f6d2ba4166a6",
+				Region: "westeurope", Zone: "1", AccountID: "8d10da13"},
+			wantID: "azure:westeurope:1:02aab8a4-74ef-476e-8182-f6d2ba4166a6",
+		},
+		{
+			// every cloud is tried if the provider is unknown
+			name: "unknown provider",
+			endpoints: cloudMetadataEndpoints{aws: unavailable.URL, gcp: unavailable.URL,
+				azure: azureServer.URL},
+			want: CloudInstance{Provider: "azure", InstanceID: "02aab8a4-74ef-476e-8182-f6d2ba4166a6",
+				Region: "westeurope", Zone: "1", AccountID: "8d10da13"},
+			wantID: "azure:westeurope:1:02aab8a4-74ef-476e-8182-f6d2ba4166a6",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance, err := fetchCloudInstance(context.Background(), http.DefaultClient.Do,
+				tt.endpoints, tt.provider)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, *instance)
+			assert.Equal(t, tt.wantID, instance.HostID())
+			assert.Equal(t, tt.wantID, instance.Env()["MW_HOST_ID"])
+		})
+	}
+
+	_, err := fetchCloudInstance(context.Background(), http.DefaultClient.Do,
+		cloudMetadataEndpoints{aws: unavailable.URL}, cloudProviderAWS)
+	assert.ErrorIs(t, err, ErrCloudMetadataUnavailable)
+}
+
+func TestHostAgentCloudInstanceHostID(t *testing.T) {
+	var hostIDs []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hostIDs = append(hostIDs, r.URL.Query().Get("host_id"))
+		_, _ = w.Write([]byte(`{"status": true, "restart": false}`))
+	}))
+	defer server.Close()
+
+	instance, err := fetchCloudInstance(context.Background(), http.DefaultClient.Do,
+		cloudMetadataEndpoints{aws: newAWSMetadataServer(t).URL}, cloudProviderAWS)
+	require.NoError(t, err)
+
+	cfg := HostConfig{
+		BaseConfig: BaseConfig{
+			APIKey:               "testAPIKey",
+			APIURLForConfigCheck: server.URL,
+		},
+	}
+
+	agent, err := NewHostAgent(cfg, zapcore.NewNopCore(), WithHostAgentCloudInstance(instance))
+	require.NoError(t, err)
+	assert.NoError(t, agent.callRestartStatusAPI(context.Background()))
+
+	// the hostname is used without cloud instance
+	agent, err = NewHostAgent(cfg, zapcore.NewNopCore())
+	require.NoError(t, err)
+	assert.NoError(t, agent.callRestartStatusAPI(context.Background()))
+
+	assert.Equal(t, []string{"aws:us-east-1:us-east-1a:i-0abc", getHostname()}, hostIDs)
+}
@@ -0,0 +1,181 @@
+package agent
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// configDiffKinds are the sections of the otel config compared by
+// diffConfigs
+var configDiffKinds = []string{
+	Receivers,
+	"processors",
+	"exporters",
+	"connectors",
+	"extensions",
+}
+
+// ComponentChanges lists the names of the components, or pipelines, which
+// changed between two otel configs.
+type ComponentChanges struct {
+	Added    []string `json:"added,omitempty"`
+	Removed  []string `json:"removed,omitempty"`
+	Modified []string `json:"modified,omitempty"`
+}
+
+func (c ComponentChanges) empty() bool {
+	return len(c.Added) == 0 && len(c.Removed) == 0 && len(c.Modified) == 0
+}
+
+// ConfigDiff is the semantic difference between two otel configs.
+type ConfigDiff struct {
+	// Components maps the config section (e.g. receivers) to its changes
+	Components map[string]ComponentChanges `json:"components,omitempty"`
+	// Pipelines lists the pipelines which were added, removed or rewired
+	Pipelines ComponentChanges `json:"pipelines"`
+}
+
+// Empty returns true if the configs have the same components and pipelines.
+func (d ConfigDiff) Empty() bool {
+	return len(d.Components) == 0 && d.Pipelines.empty()
+}
+
+// forEach calls fn for every non empty list of changes in d, e.g. with
+// (receivers, added, [otlp]).
+func (d ConfigDiff) forEach(fn func(section string, change string, names []string)) {
+	visit := func(section string, changes ComponentChanges) {
+		if len(changes.Added) > 0 {
+			fn(section, "added", changes.Added)
+		}
+		if len(changes.Removed) > 0 {
+			fn(section, "removed", changes.Removed)
+		}
+		if len(changes.Modified) > 0 {
+			fn(section, "modified", changes.Modified)
+		}
+	}
+
+	for _, kind := range configDiffKinds {
+		visit(kind, d.Components[kind])
+	}
+	visit(Pipelines, d.Pipelines)
+}
+
+// zapFields returns the changes as zap fields, e.g. receivers_added.
+func (d ConfigDiff) zapFields() []zap.Field {
+	var fields []zap.Field
+	d.forEach(func(section string, change string, names []string) {
+		fields = append(fields, zap.Strings(section+"_"+change, names))
+	})
+	return fields
+}
+
+// Summary returns the changes as human readable lines, e.g.
+// "receivers added: otlp".
+func (d ConfigDiff) Summary() []string {
+	var lines []string
+	d.forEach(func(section string, change string, names []string) {
+		lines = append(lines, fmt.Sprintf("%s %s: %s", section, change, strings.Join(names, ", ")))
+	})
+	return lines
+}
+
+// ConfigChange is a change of the otel config applied by the agent.
+type ConfigChange struct {
+	Time time.Time  `json:"time"`
+	Diff ConfigDiff `json:"diff"`
+}
+
+// diffSection compares the entries of the given config section, which map
+// a name to its config.
+func diffSection(oldSection, newSection map[string]interface{},
+	modified func(oldValue, newValue interface{}) bool) ComponentChanges {
+	var changes ComponentChanges
+	for name, newValue := range newSection {
+		oldValue, ok := oldSection[name]
+		if !ok {
+			changes.Added = append(changes.Added, name)
+		} else if modified(oldValue, newValue) {
+			changes.Modified = append(changes.Modified, name)
+		}
+	}
+
+	for name := range oldSection {
+		if _, ok := newSection[name]; !ok {
+			changes.Removed = append(changes.Removed, name)
+		}
+	}
+
+	sort.Strings(changes.Added)
+	sort.Strings(changes.Removed)
+	sort.Strings(changes.Modified)
+	return changes
+}
+
+// pipelineRewired checks whether the receivers, processors or exporters of
+// a pipeline changed.
+func pipelineRewired(oldValue, newValue interface{}) bool {
+	oldPipeline, _ := oldValue.(map[string]interface{})
+	newPipeline, _ := newValue.(map[string]interface{})
+	for _, key := range []string{Receivers, "processors", "exporters"} {
+		if !reflect.DeepEqual(componentNames(oldPipeline[key]), componentNames(newPipeline[key])) {
+			return true
+		}
+	}
+	return false
+}
+
+// diffConfigs returns the components and pipelines which changed from
+// oldConfig to newConfig.
+func diffConfigs(oldConfig, newConfig map[string]interface{}) ConfigDiff {
+	diff := ConfigDiff{
+		Components: map[string]ComponentChanges{},
+	}
+
+	for _, kind := range configDiffKinds {
+		changes := diffSection(configSection(oldConfig, kind), configSection(newConfig, kind),
+			func(oldValue, newValue interface{}) bool {
+				return !reflect.DeepEqual(oldValue, newValue)
+			})
+		if !changes.empty() {
+			diff.Components[kind] = changes
+		}
+	}
+
+	diff.Pipelines = diffSection(configSection(oldConfig, Service, Pipelines),
+		configSection(newConfig, Service, Pipelines), pipelineRewired)
+
+	return diff
+}
+
+// recordConfigChange logs the changes from the otel config in oldData to the
+// one in newData and keeps them in the agent state. Configs which can't be
+// parsed are treated as empty.
+func (c *HostAgent) recordConfigChange(oldData, newData []byte) {
+	oldConfig, err := parseConfig(oldData)
+	if err != nil {
+		oldConfig = map[string]interface{}{}
+	}
+
+	newConfig, err := parseConfig(newData)
+	if err != nil {
+		newConfig = map[string]interface{}{}
+	}
+
+	diff := diffConfigs(oldConfig, newConfig)
+	if diff.Empty() {
+		c.logger.Info("otel config changed without changes to components or pipelines")
+	} else {
+		c.logger.Info("otel config changed", diff.zapFields()...)
+	}
+
+	c.state.recordConfigChange(ConfigChange{
+		Time: time.Now(),
+		Diff: diff,
+	})
+}
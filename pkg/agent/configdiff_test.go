@@ -0,0 +1,124 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+const diffOldConfig = `
+receivers:
+  otlp:
+    protocols:
+      grpc:
+  filelog:
+    include: [/var/log/*.log]
+  hostmetrics:
+    collection_interval: 10s
+processors:
+  batch:
+exporters:
+  otlp:
+    endpoint: https://example.com
+service:
+  pipelines:
+    metrics:
+      receivers: [hostmetrics, otlp]
+      processors: [batch]
+      exporters: [otlp]
+    logs:
+      receivers: [filelog]
+      exporters: [otlp]
+`
+
+const diffNewConfig = `
+receivers:
+  otlp:
+    protocols:
+      grpc:
+  hostmetrics:
+    collection_interval: 30s
+  docker_stats:
+processors:
+  batch:
+exporters:
+  otlp:
+    endpoint: https://example.com
+service:
+  pipelines:
+    metrics:
+      receivers: [hostmetrics, otlp, docker_stats]
+      processors: [batch]
+      exporters: [otlp]
+    traces:
+      receivers: [otlp]
+      exporters: [otlp]
+`
+
+func TestDiffConfigs(t *testing.T) {
+	oldConfig, err := parseConfig([]byte(diffOldConfig))
+	require.NoError(t, err)
+	newConfig, err := parseConfig([]byte(diffNewConfig))
+	require.NoError(t, err)
+
+	diff := diffConfigs(oldConfig, newConfig)
+	assert.False(t, diff.Empty())
+	assert.Equal(t, map[string]ComponentChanges{
+		Receivers: {
+			Added:    []string{"docker_stats"},
+			Removed:  []string{"filelog"},
+			Modified: []string{"hostmetrics"},
+		},
+	}, diff.Components)
+	assert.Equal(t, ComponentChanges{
+		Added:    []string{"traces"},
+		Removed:  []string{"logs"},
+		Modified: []string{"metrics"},
+	}, diff.Pipelines)
+
+	assert.Equal(t, []string{
+		"receivers added: docker_stats",
+		"receivers removed: filelog",
+		"receivers modified: hostmetrics",
+		"pipelines added: traces",
+		"pipelines removed: logs",
+		"pipelines modified: metrics",
+	}, diff.Summary())
+
+	assert.True(t, diffConfigs(oldConfig, oldConfig).Empty())
+	assert.Equal(t, []string{"filelog", "hostmetrics", "otlp"},
+		diffConfigs(map[string]interface{}{}, oldConfig).Components[Receivers].Added)
+}
+
+func TestApplyConfigRecordsChange(t *testing.T) {
+	observedCore, logs := observer.New(zapcore.InfoLevel)
+	agent, err := NewHostAgent(HostConfig{
+		BaseConfig: BaseConfig{
+			OtelConfigFile: filepath.Join(t.TempDir(), "otel-config.yaml"),
+		},
+	}, observedCore)
+	require.NoError(t, err)
+	closeAgent(t, agent)
+
+	require.NoError(t, os.WriteFile(agent.OtelConfigFile, []byte(diffOldConfig), 0644))
+	assert.Nil(t, agent.Status().LastConfigChange)
+
+	require.NoError(t, agent.applyConfig([]byte(diffNewConfig)))
+
+	change := agent.Status().LastConfigChange
+	require.NotNil(t, change)
+	assert.Equal(t, []string{"traces"}, change.Diff.Pipelines.Added)
+
+	entries := logs.FilterMessage("otel config changed").All()
+	require.Len(t, entries, 1)
+	fields := entries[0].ContextMap()
+	assert.Equal(t, []interface{}{"docker_stats"}, fields["receivers_added"])
+	assert.Equal(t, []interface{}{"logs"}, fields["pipelines_removed"])
+	assert.NotContains(t, fields, "exporters_added")
+
+}
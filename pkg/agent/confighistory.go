@@ -0,0 +1,146 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	configHistoryDirName  = "otel-config-history"
+	configHistoryExt      = ".yaml"
+	configHistoryLastGood = "last-good"
+)
+
+var (
+	ErrNoLastGoodConfig = errors.New("no last known good config found")
+)
+
+// configHistory keeps a versioned history of the otel configs applied
+// by the agent along with a pointer to the last config that was known
+// to run the collector successfully.
+type configHistory struct {
+	dir         string
+	maxVersions int
+}
+
+func newConfigHistory(dir string, maxVersions int) *configHistory {
+	return &configHistory{
+		dir:         dir,
+		maxVersions: maxVersions,
+	}
+}
+
+// record stores data as a new version in the history and returns
+// the name of the version.
+func (h *configHistory) record(data []byte) (string, error) {
+	if err := os.MkdirAll(h.dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create config history directory %s: %w", h.dir, err)
+	}
+
+	version := fmt.Sprintf("%020d", time.Now().UnixNano())
+	path := filepath.Join(h.dir, version+configHistoryExt)
+	if err := writeFileAtomic(path, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write config version %s: %w", version, err)
+	}
+
+	if err := h.prune(); err != nil {
+		return version, err
+	}
+
+	return version, nil
+}
+
+// versions returns the versions in the history, oldest first.
+func (h *configHistory) versions() ([]string, error) {
+	entries, err := os.ReadDir(h.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+
+	versions := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, configHistoryExt) {
+			continue
+		}
+		versions = append(versions, strings.TrimSuffix(name, configHistoryExt))
+	}
+
+	sort.Strings(versions)
+	return versions, nil
+}
+
+// prune removes the oldest versions beyond maxVersions. The last known
+// good version is never removed.
+func (h *configHistory) prune() error {
+	versions, err := h.versions()
+	if err != nil {
+		return err
+	}
+
+	lastGood, _ := h.lastGoodVersion()
+	excess := len(versions) - h.maxVersions
+	for _, version := range versions {
+		if excess <= 0 {
+			break
+		}
+
+		if version == lastGood {
+			continue
+		}
+
+		path := filepath.Join(h.dir, version+configHistoryExt)
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove config version %s: %w", version, err)
+		}
+		excess--
+	}
+
+	return nil
+}
+
+// markGood records version as the last known good config.
+func (h *configHistory) markGood(version string) error {
+	path := filepath.Join(h.dir, version+configHistoryExt)
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("config version %s not found: %w", version, err)
+	}
+
+	return writeFileAtomic(filepath.Join(h.dir, configHistoryLastGood), []byte(version), 0644)
+}
+
+func (h *configHistory) lastGoodVersion() (string, error) {
+	data, err := os.ReadFile(filepath.Join(h.dir, configHistoryLastGood))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", ErrNoLastGoodConfig
+		}
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+// lastGood returns the version name and contents of the last known
+// good config.
+func (h *configHistory) lastGood() (string, []byte, error) {
+	version, err := h.lastGoodVersion()
+	if err != nil {
+		return "", nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(h.dir, version+configHistoryExt))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read last good config version %s: %w", version, err)
+	}
+
+	return version, data, nil
+}
@@ -0,0 +1,140 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestConfigHistoryRecordAndPrune(t *testing.T) {
+	h := newConfigHistory(filepath.Join(t.TempDir(), configHistoryDirName), 2)
+
+	first, err := h.record([]byte("first"))
+	assert.NoError(t, err)
+	assert.NoError(t, h.markGood(first))
+
+	_, err = h.record([]byte("second"))
+	assert.NoError(t, err)
+	third, err := h.record([]byte("third"))
+	assert.NoError(t, err)
+
+	versions, err := h.versions()
+	assert.NoError(t, err)
+
+	// the last good version must survive pruning
+	assert.Equal(t, []string{first, third}, versions)
+
+	version, data, err := h.lastGood()
+	assert.NoError(t, err)
+	assert.Equal(t, first, version)
+	assert.Equal(t, []byte("first"), data)
+}
+
+func TestConfigHistoryNoLastGood(t *testing.T) {
+	h := newConfigHistory(filepath.Join(t.TempDir(), configHistoryDirName), 2)
+
+	_, _, err := h.lastGood()
+	assert.ErrorIs(t, err, ErrNoLastGoodConfig)
+	assert.Error(t, h.markGood("missing"))
+}
+
+func TestHostAgentRollbackConfig(t *testing.T) {
+	otelConfigFile := filepath.Join(t.TempDir(), "otel-config.yaml")
+
+	agent, err := NewHostAgent(HostConfig{
+		BaseConfig: BaseConfig{
+			OtelConfigFile: otelConfigFile,
+		},
+		ConfigHistorySize: 3,
+	}, zapcore.NewNopCore())
+	assert.NoError(t, err)
+	closeAgent(t, agent)
+
+	// nothing to roll back to
+	assert.ErrorIs(t, agent.rollbackConfig(), ErrNoLastGoodConfig)
+
+	assert.NoError(t, os.WriteFile(otelConfigFile, []byte("good"), 0644))
+	agent.recordAppliedConfig([]byte("good"))
+	agent.promoteAppliedConfig()
+	assert.Empty(t, agent.pendingConfigVersion)
+
+	assert.NoError(t, os.WriteFile(otelConfigFile, []byte("bad"), 0644))
+	agent.recordAppliedConfig([]byte("bad"))
+	assert.NotEmpty(t, agent.pendingConfigVersion)
+
+	assert.NoError(t, agent.rollbackConfig())
+	assert.Empty(t, agent.pendingConfigVersion)
+
+	data, err := os.ReadFile(otelConfigFile)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("good"), data)
+
+	// a config that is already known good is never rolled back
+	assert.ErrorIs(t, agent.rollbackConfig(), ErrNoLastGoodConfig)
+}
+
+func TestHostAgentRollbackConfigNoETag(t *testing.T) {
+	// the source serves the same config every time, without an ETag
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/nodocker.yaml" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(sourceTestConfig))
+	}))
+	defer server.Close()
+
+	otelConfigFile := filepath.Join(t.TempDir(), "otel-config.yaml")
+	agent, err := NewHostAgent(HostConfig{
+		BaseConfig: BaseConfig{
+			OtelConfigFile: otelConfigFile,
+			ConfigSource:   server.URL + "/",
+			AgentFeatures: AgentFeatures{
+				MetricCollection: true,
+			},
+		},
+		ConfigHistorySize: 3,
+	}, zapcore.NewNopCore())
+	assert.NoError(t, err)
+	closeAgent(t, agent)
+
+	assert.NoError(t, os.WriteFile(otelConfigFile, []byte("good"), 0644))
+	agent.recordAppliedConfig([]byte("good"))
+	agent.promoteAppliedConfig()
+
+	// the collector fails with the config from the source
+	assert.ErrorIs(t, agent.checkConfigChanges(context.Background()), ErrRestartAgent)
+	assert.NoError(t, agent.rollbackConfig())
+
+	// so it is not applied again
+	assert.NoError(t, agent.checkConfigChanges(context.Background()))
+	data, err := os.ReadFile(otelConfigFile)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("good"), data)
+}
+
+func TestHostAgentPromoteSeedsHistory(t *testing.T) {
+	otelConfigFile := filepath.Join(t.TempDir(), "otel-config.yaml")
+	assert.NoError(t, os.WriteFile(otelConfigFile, []byte("existing"), 0644))
+
+	agent, err := NewHostAgent(HostConfig{
+		BaseConfig: BaseConfig{
+			OtelConfigFile: otelConfigFile,
+		},
+		ConfigHistorySize: 3,
+	}, zapcore.NewNopCore())
+	assert.NoError(t, err)
+	closeAgent(t, agent)
+
+	agent.promoteAppliedConfig()
+
+	_, data, err := agent.configHistory.lastGood()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("existing"), data)
+}
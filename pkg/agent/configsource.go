@@ -0,0 +1,445 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+)
+
+// ConfigSourceMiddleware is the config source spec of Middleware backend,
+// which is the default config source.
+const ConfigSourceMiddleware = "middleware"
+
+// Files read by the directory and URL config sources
+const (
+	// defaultSourceConfigName is read if there is no file for the config type
+	defaultSourceConfigName = "config.yaml"
+	// sourceIntegrationsDirName holds the integration configs in a directory
+	sourceIntegrationsDirName = "integrations"
+)
+
+// emptyPayloadHash is the hex encoded sha256 of an empty request body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+var ErrInvalidConfigSource = errors.New("invalid config source")
+
+// ConfigRequest describes the otel config requested from a ConfigSource.
+type ConfigRequest struct {
+	// Type is docker or nodocker on hosts, deployment or daemonset on
+	// Kubernetes
+	Type string
+	// ETag is the ETag of the config last applied from the source, if any
+	ETag string
+}
+
+// SourceConfig is an otel config fetched from a ConfigSource, before the
+// agent applies its rewrites to it.
+type SourceConfig struct {
+	Config map[string]interface{}
+	// Integrations are merged into the receivers of Config by the host agent
+	Integrations map[IntegrationType]IntegrationConfig
+	// ETag identifies the version of the config. It is empty if the source
+	// doesn't version its configs.
+	ETag string
+
+	// Payload is the config as fetched, e.g. the api response body, and
+	// Signature is the base64 ed25519 signature of Payload, if signed.
+	Payload   []byte
+	Signature string
+	// UnsignedIntegrations is set if Integrations are read from files which
+	// Signature doesn't cover
+	UnsignedIntegrations bool
+}
+
+// ConfigSource provides the otel configs applied by the agent, e.g.
+// Middleware backend or a GitOps repo.
+type ConfigSource interface {
+	// FetchConfig returns the otel config for req. It returns
+	// ErrConfigUnchanged if the config still has req.ETag.
+	FetchConfig(ctx context.Context, req ConfigRequest) (*SourceConfig, error)
+	// String describes the source in logs
+	String() string
+}
+
+// NewConfigSource returns the config source for the given spec, which is
+// one of
+//   - an absolute path or a file:// URL of a directory (see DirConfigSource)
+//   - an http:// or https:// URL (see URLConfigSource)
+//   - an s3://<bucket>/<prefix> URL (see NewS3ConfigSource)
+//
+// It returns nil for an empty spec or ConfigSourceMiddleware, as the agents
+// fetch from Middleware backend by default. do is used for the HTTP calls.
+func NewConfigSource(spec string,
+	do func(req *http.Request) (*http.Response, error)) (ConfigSource, error) {
+	if spec == "" || spec == ConfigSourceMiddleware {
+		return nil, nil
+	}
+
+	if filepath.IsAbs(spec) {
+		return NewDirConfigSource(spec), nil
+	}
+
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidConfigSource, err)
+	}
+
+	switch u.Scheme {
+	case "file":
+		return NewDirConfigSource(u.Path), nil
+	case "http", "https":
+		return NewURLConfigSource(spec, do), nil
+	case "s3":
+		return NewS3ConfigSource(u.Host, u.Path, do)
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrInvalidConfigSource, u.Redacted())
+}
+
+// DirConfigSource reads the otel configs from a local directory, e.g. a
+// checkout of a GitOps repo. The config of each type is read from
+// <dir>/<type>.yaml, or <dir>/config.yaml if there is none, and the
+// integration configs from <dir>/integrations/<integration>.yaml. The
+// signature of a config is read from the file with the .sig suffix. It
+// doesn't cover the integration configs, so they are refused if configs
+// must be signed.
+type DirConfigSource struct {
+	dir string
+}
+
+// NewDirConfigSource returns the config source for the given directory.
+func NewDirConfigSource(dir string) *DirConfigSource {
+	return &DirConfigSource{dir: dir}
+}
+
+func (s *DirConfigSource) String() string {
+	return "dir:" + s.dir
+}
+
+// FetchConfig implements ConfigSource. The ETag is the hash of the files
+// read. A config which can't be read, e.g. while a checkout is in
+// progress, is an ErrTransient error, and one which can't be parsed an
+// ErrInvalidConfig error, so that the collector keeps running.
+func (s *DirConfigSource) FetchConfig(_ context.Context,
+	req ConfigRequest) (*SourceConfig, error) {
+	path := filepath.Join(s.dir, req.Type+".yaml")
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		path = filepath.Join(s.dir, defaultSourceConfigName)
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read %s config from %s: %w", ErrTransient,
+			req.Type, s.dir, err)
+	}
+
+	hash := sha256.New()
+	hash.Write(data)
+
+	integrations := map[IntegrationType]IntegrationConfig{}
+	for _, integrationType := range integrationTypes {
+		integrationPath := filepath.Join(s.dir, sourceIntegrationsDirName,
+			integrationType.String()+".yaml")
+		integrationData, err := os.ReadFile(integrationPath)
+		if err != nil {
+			continue
+		}
+
+		hash.Write(integrationData)
+		integrations[integrationType] = IntegrationConfig{Path: integrationPath}
+	}
+
+	etag := hex.EncodeToString(hash.Sum(nil))
+	if etag == req.ETag {
+		return nil, ErrConfigUnchanged
+	}
+
+	config, err := parseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to parse %s: %v", ErrInvalidConfig, path, err)
+	}
+
+	signature, err := os.ReadFile(path + signatureFileSuffix)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: failed to read signature of %s: %v", ErrTransient, path, err)
+	}
+
+	return &SourceConfig{
+		Config:               config,
+		Integrations:         integrations,
+		ETag:                 etag,
+		Payload:              data,
+		Signature:            string(signature),
+		UnsignedIntegrations: true,
+	}, nil
+}
+
+// URLConfigSource fetches the otel configs over HTTP(S), e.g. from a GitOps
+// server or an S3-compatible object store. The config of each type is
+// fetched from <url>/<type>.yaml, or <url>/config.yaml if there is none,
+// along with its signature from the file with the .sig suffix. Integration
+// configs are not supported as they refer to local files.
+type URLConfigSource struct {
+	url string
+	do  func(req *http.Request) (*http.Response, error)
+	// sign signs the requests. Nil sends them unsigned.
+	sign func(req *http.Request) error
+	// forbiddenIsNotFound handles 403 like 404. S3 answers 403 for missing
+	// keys unless the caller may list the bucket.
+	forbiddenIsNotFound bool
+}
+
+// NewURLConfigSource returns the config source for the given base URL. The
+// API key of the agent is never sent to it.
+func NewURLConfigSource(baseURL string,
+	do func(req *http.Request) (*http.Response, error)) *URLConfigSource {
+	return &URLConfigSource{
+		url: strings.TrimSuffix(baseURL, "/"),
+		do:  do,
+	}
+}
+
+// NewS3ConfigSource returns the config source for the given prefix of an S3
+// bucket. The requests are signed with the credentials of the default AWS
+// credential chain, e.g. the environment, the shared config files, IRSA or
+// the instance profile and ECS task role, which are refreshed as they
+// expire. The region is read from AWS_REGION or the shared config and the
+// endpoint of an S3-compatible store from AWS_ENDPOINT_URL_S3 or
+// AWS_ENDPOINT_URL.
+func NewS3ConfigSource(bucket string, prefix string,
+	do func(req *http.Request) (*http.Response, error)) (*URLConfigSource, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("%w: bucket is empty", ErrInvalidConfigSource)
+	}
+
+	awsConfig, err := awsconfig.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to load AWS config: %v", ErrInvalidConfigSource, err)
+	}
+
+	region := awsConfig.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	endpoint := firstEnv("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL")
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+
+	// path-style URLs are supported by AWS and the S3-compatible stores
+	baseURL, err := url.JoinPath(endpoint, bucket, strings.Trim(prefix, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidConfigSource, err)
+	}
+
+	source := NewURLConfigSource(baseURL, do)
+	source.forbiddenIsNotFound = true
+
+	signer := v4.NewSigner()
+	source.sign = func(req *http.Request) error {
+		// the credentials are cached by the provider until they expire
+		credentials, err := awsConfig.Credentials.Retrieve(req.Context())
+		if err != nil {
+			return fmt.Errorf("failed to retrieve AWS credentials: %w", err)
+		}
+
+		req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+		return signer.SignHTTP(req.Context(), credentials, req, emptyPayloadHash,
+			"s3", region, time.Now())
+	}
+
+	return source, nil
+}
+
+// redactConfigSource removes the credentials from the URL of a config
+// source spec.
+func redactConfigSource(spec string) string {
+	if u, err := url.Parse(spec); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return spec
+}
+
+func firstEnv(keys ...string) string {
+	for _, key := range keys {
+		if v := os.Getenv(key); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func (s *URLConfigSource) String() string {
+	return redactConfigSource(s.url)
+}
+
+// FetchConfig implements ConfigSource. The ETag is the one returned by the
+// server. A config which can't be fetched is an ErrTransient error, and one
+// which can't be parsed an ErrInvalidConfig error, so that the collector
+// keeps running.
+func (s *URLConfigSource) FetchConfig(ctx context.Context,
+	req ConfigRequest) (*SourceConfig, error) {
+	name := req.Type + ".yaml"
+	data, etag, err := s.fetch(ctx, name, req.ETag)
+	if errors.Is(err, os.ErrNotExist) {
+		name = defaultSourceConfigName
+		data, etag, err = s.fetch(ctx, name, req.ETag)
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %w", ErrTransient, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := parseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to parse %s config from %s: %v", ErrInvalidConfig,
+			req.Type, s, err)
+	}
+
+	signature, _, err := s.fetch(ctx, name+signatureFileSuffix, "")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	return &SourceConfig{
+		Config:    config,
+		ETag:      etag,
+		Payload:   data,
+		Signature: string(signature),
+	}, nil
+}
+
+// fetch gets the given file from the source. It returns an error wrapping
+// os.ErrNotExist if the file is not found, and one wrapping ErrTransient if
+// the source fails otherwise.
+func (s *URLConfigSource) fetch(ctx context.Context, name string,
+	etag string) ([]byte, string, error) {
+	fileURL := s.url + "/" + name
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	if s.sign != nil {
+		if err := s.sign(req); err != nil {
+			return nil, "", fmt.Errorf("%w: failed to sign request: %v", ErrTransient, err)
+		}
+	}
+
+	redactedURL := redactConfigSource(fileURL)
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: failed to fetch %s: %v", ErrTransient, redactedURL, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		return nil, "", ErrConfigUnchanged
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, "", fmt.Errorf("%s: %w", redactedURL, os.ErrNotExist)
+	case resp.StatusCode == http.StatusForbidden && s.forbiddenIsNotFound:
+		return nil, "", fmt.Errorf("%s returned status: %d: %w", redactedURL, resp.StatusCode,
+			os.ErrNotExist)
+	case isRetryableStatus(resp.StatusCode):
+		return nil, "", fmt.Errorf("%w: %s returned status: %d", ErrTransient,
+			redactedURL, resp.StatusCode)
+	case resp.StatusCode != http.StatusOK:
+		return nil, "", fmt.Errorf("%w: %s returned non-200 status: %d", ErrTransient,
+			redactedURL, resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: failed to read %s: %v", ErrTransient, redactedURL, err)
+	}
+
+	return data, resp.Header.Get("ETag"), nil
+}
+
+// sourceConfig returns the otel config of the given type from the response
+// of the get configuration api.
+func (r *apiResponseForYAML) sourceConfig(configType string, etag string) (*SourceConfig, error) {
+	if !r.Status {
+		return nil, fmt.Errorf("failure status from api response for ingestion rules: %t", r.Status)
+	}
+
+	var config map[string]interface{}
+	switch configType {
+	case "docker":
+		config = r.Config.Docker
+	case "nodocker":
+		config = r.Config.NoDocker
+	case Deployment.String():
+		config = r.Config.Deployment
+	case DaemonSet.String():
+		config = r.Config.DaemonSet
+	}
+
+	if len(config) == 0 {
+		return nil, fmt.Errorf("failed to get valid response, %s config is empty", configType)
+	}
+
+	return &SourceConfig{
+		Config: config,
+		Integrations: map[IntegrationType]IntegrationConfig{
+			PostgreSQL:    r.PgdbConfig,
+			MongoDB:       r.MongodbConfig,
+			MySQL:         r.MysqlConfig,
+			Redis:         r.RedisConfig,
+			Elasticsearch: r.ElasticsearchConfig,
+			Cassandra:     r.CassandraConfig,
+			Clickhouse:    r.ClickhouseConfig,
+		},
+		ETag: etag,
+	}, nil
+}
+
+// hostAPIConfigSource fetches the otel configs of the host agent from
+// Middleware backend.
+type hostAPIConfigSource struct {
+	agent *HostAgent
+}
+
+func (s *hostAPIConfigSource) String() string {
+	return ConfigSourceMiddleware
+}
+
+func (s *hostAPIConfigSource) FetchConfig(ctx context.Context,
+	req ConfigRequest) (*SourceConfig, error) {
+	return s.agent.fetchConfig(ctx, req.Type, req.ETag)
+}
+
+// kubeAPIConfigSource fetches the otel configs of the Kubernetes agent from
+// Middleware backend.
+type kubeAPIConfigSource struct {
+	monitor *KubeAgentMonitor
+}
+
+func (s *kubeAPIConfigSource) String() string {
+	return ConfigSourceMiddleware
+}
+
+func (s *kubeAPIConfigSource) FetchConfig(ctx context.Context,
+	req ConfigRequest) (*SourceConfig, error) {
+	return s.monitor.fetchConfig(ctx, req.Type)
+}
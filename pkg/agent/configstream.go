@@ -0,0 +1,147 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var apiPathForConfigStream = "api/v1/agent/config-stream"
+
+// Events pushed by the backend over the config stream. Both make the agent
+// check for config changes right away.
+const (
+	configStreamEventConfig  = "config"
+	configStreamEventRestart = "restart"
+)
+
+// configStreamIdleTimeout is the time after which the config stream is
+// considered dead if nothing, not even a heartbeat, was received on it.
+const configStreamIdleTimeout = 2 * time.Minute
+
+// configStreamMinUptime is the time for which a config stream needs to stay
+// open, if nothing was received on it, to be considered healthy.
+const configStreamMinUptime = 30 * time.Second
+
+// configStreamBackoff controls the reconnects of the config stream. There
+// is no limit on the attempts as polling covers for the stream meanwhile.
+var configStreamBackoff = backoff{
+	initial: time.Second,
+	max:     5 * time.Minute,
+}
+
+// runConfigStream keeps a config stream open to the backend until ctx is
+// done and sends to notify whenever the backend pushes a config change.
+// The stream is reopened if it drops.
+func (c *HostAgent) runConfigStream(ctx context.Context, notify chan<- struct{}) {
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		received, err := c.streamConfigEvents(ctx, notify)
+		if ctx.Err() != nil {
+			return
+		}
+
+		// A backend or proxy which accepts the stream only to close it right
+		// away must not get reconnects without backoff.
+		if received || time.Since(start) >= configStreamMinUptime {
+			attempt = 1
+		}
+
+		delay := c.streamBackoff.delay(attempt)
+		c.logger.Warn("config stream dropped, falling back to polling until it reconnects",
+			zap.Duration("delay", delay), zap.Error(err))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// streamConfigEvents reads the Server-Sent Events of the config stream
+// until the stream drops. received is true if anything, even a heartbeat,
+// was received on the stream.
+func (c *HostAgent) streamConfigEvents(ctx context.Context,
+	notify chan<- struct{}) (received bool, err error) {
+	u, err := c.apiURL(apiPathForConfigStream)
+	if err != nil {
+		return false, err
+	}
+
+	params := url.Values{}
+	params.Add("host_id", c.HostID())
+	if c.agentID != "" {
+		params.Add("agent_id", c.agentID)
+	}
+	params.Add("platform", runtime.GOOS)
+	params.Add("agent_version", c.Version)
+	params.Add("infra_platform", fmt.Sprint(c.InfraPlatform))
+	u.RawQuery = params.Encode()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	c.setAPIKey(req)
+
+	resp, err := c.streamDoFunc(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to open config stream %s: %w",
+			c.redactURL(u.String()), c.redactError(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, statusError("config stream", resp.StatusCode)
+	}
+
+	c.logger.Info("config stream connected")
+
+	// drop the stream if the backend stops sending heartbeats
+	idleTimer := time.AfterFunc(configStreamIdleTimeout, cancel)
+	defer idleTimer.Stop()
+
+	var event string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		idleTimer.Reset(configStreamIdleTimeout)
+		received = true
+
+		line := scanner.Text()
+		switch {
+		case line == "":
+			// a blank line dispatches the event
+			if event == configStreamEventConfig || event == configStreamEventRestart {
+				c.logger.Info("config change pushed by backend", zap.String("event", event))
+				select {
+				case notify <- struct{}{}:
+				default:
+					// a check is already pending
+				}
+			}
+			event = ""
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		}
+		// data, id, retry & comment (heartbeat) lines are not used
+	}
+
+	if err := scanner.Err(); err != nil {
+		return received, c.redactError(err)
+	}
+	return received, fmt.Errorf("config stream closed by backend")
+}
@@ -0,0 +1,131 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+func newStreamAgent(t *testing.T, apiURL string) *HostAgent {
+	agent, err := NewHostAgent(HostConfig{
+		BaseConfig: BaseConfig{
+			APIKey:               "secretkey",
+			APIKeyMode:           APIKeyModeHeader,
+			APIURLForConfigCheck: apiURL,
+			ConfigCheckInterval:  "0",
+		},
+		ConfigStream: true,
+	}, zapcore.NewNopCore())
+	require.NoError(t, err)
+
+	agent.backoff = backoff{}
+	agent.streamBackoff = backoff{initial: time.Millisecond, max: time.Millisecond}
+	return agent
+}
+
+func TestStreamConfigEvents(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/"+apiPathForConfigStream, r.URL.Path)
+		assert.Equal(t, "Bearer secretkey", r.Header.Get("Authorization"))
+		assert.Equal(t, "text/event-stream", r.Header.Get("Accept"))
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, ": heartbeat\n\n")
+		fmt.Fprint(w, "event: unknown\ndata: {}\n\n")
+		fmt.Fprint(w, "event: config\ndata: {}\n\n")
+		fmt.Fprint(w, "event: restart\ndata: {}\n\n")
+	}))
+	defer server.Close()
+
+	agent := newStreamAgent(t, server.URL)
+
+	notify := make(chan struct{}, 1)
+	received, err := agent.streamConfigEvents(context.Background(), notify)
+	assert.True(t, received)
+	assert.Error(t, err)
+
+	// the second event is dropped as a check is already pending
+	assert.Len(t, notify, 1)
+}
+
+func TestStreamConfigEventsRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	agent := newStreamAgent(t, server.URL)
+
+	received, err := agent.streamConfigEvents(context.Background(), make(chan struct{}, 1))
+	assert.False(t, received)
+	assert.ErrorIs(t, err, ErrAuthRevoked)
+}
+
+func TestRunConfigStreamClosedImmediately(t *testing.T) {
+	// the backend accepts the stream, but closes it without sending anything
+	var connects atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		connects.Add(1)
+		w.Header().Set("Content-Type", "text/event-stream")
+	}))
+	defer server.Close()
+
+	agent := newStreamAgent(t, server.URL)
+	agent.streamBackoff = backoff{initial: 10 * time.Millisecond, max: time.Minute}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	agent.runConfigStream(ctx, make(chan struct{}, 1))
+
+	// the reconnects back off instead of being retried every 10ms
+	assert.Less(t, connects.Load(), int32(20))
+}
+
+func TestListenForConfigChangesStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, apiPathForConfigStream):
+			fmt.Fprint(w, "event: config\ndata: {}\n\n")
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		case strings.HasSuffix(r.URL.Path, apiPathForRestart):
+			fmt.Fprint(w, `{"status": true, "restart": false}`)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	agent := newStreamAgent(t, server.URL)
+
+	errCh := make(chan error)
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		assert.NoError(t, agent.ListenForConfigChanges(errCh, stopCh))
+		close(done)
+	}()
+
+	// the initial fetch fails
+	assert.ErrorIs(t, <-errCh, ErrTransient)
+
+	// polling is disabled, so the check is triggered by the stream
+	select {
+	case err := <-errCh:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("config change pushed by the backend was not checked")
+	}
+
+	close(stopCh)
+	<-done
+}
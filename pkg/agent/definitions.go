@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io/fs"
+	"net/http"
 	"net/url"
 	"os"
 	"strings"
@@ -48,6 +49,20 @@ var (
 	InfraPlatformECSFargate InfraPlatform = 3
 	// InfraPlatformCycleIO is for Cycle.io platform
 	InfraPlatformCycleIO InfraPlatform = 4
+	// InfraPlatformEKS is for the nodes of AWS EKS
+	InfraPlatformEKS InfraPlatform = 5
+	// InfraPlatformGKE is for the nodes of Google GKE
+	InfraPlatformGKE InfraPlatform = 6
+	// InfraPlatformAKS is for the nodes of Azure AKS
+	InfraPlatformAKS InfraPlatform = 7
+	// InfraPlatformNomad is for HashiCorp Nomad allocations
+	InfraPlatformNomad InfraPlatform = 8
+	// InfraPlatformDocker is for plain Docker containers
+	InfraPlatformDocker InfraPlatform = 9
+	// InfraPlatformSystemdNspawn is for systemd-nspawn containers
+	InfraPlatformSystemdNspawn InfraPlatform = 10
+	// InfraPlatformLXC is for LXC and LXD containers
+	InfraPlatformLXC InfraPlatform = 11
 )
 
 func (p InfraPlatform) String() string {
@@ -62,19 +77,101 @@ func (p InfraPlatform) String() string {
 		return "ecsfargate"
 	case InfraPlatformCycleIO:
 		return "cycleio"
+	case InfraPlatformEKS:
+		return "eks"
+	case InfraPlatformGKE:
+		return "gke"
+	case InfraPlatformAKS:
+		return "aks"
+	case InfraPlatformNomad:
+		return "nomad"
+	case InfraPlatformDocker:
+		return "docker"
+	case InfraPlatformSystemdNspawn:
+		return "systemdnspawn"
+	case InfraPlatformLXC:
+		return "lxc"
 	}
 	return "unknown"
 }
 
+var ErrInvalidInfraPlatform = fmt.Errorf("invalid infra platform")
+
+// ParseInfraPlatform returns the InfraPlatform whose String() is s.
+func ParseInfraPlatform(s string) (InfraPlatform, error) {
+	for _, p := range []InfraPlatform{
+		InfraPlatformInstance,
+		InfraPlatformKubernetes,
+		InfraPlatformECSEC2,
+		InfraPlatformECSFargate,
+		InfraPlatformCycleIO,
+		InfraPlatformEKS,
+		InfraPlatformGKE,
+		InfraPlatformAKS,
+		InfraPlatformNomad,
+		InfraPlatformDocker,
+		InfraPlatformSystemdNspawn,
+		InfraPlatformLXC,
+	} {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return InfraPlatformInstance, fmt.Errorf("%w: %s", ErrInvalidInfraPlatform, s)
+}
+
 type AgentFeatures struct {
 	MetricCollection    bool
 	LogCollection       bool
 	SyntheticMonitoring bool
+
+	// The features below are enabled unless they are disabled, so that the
+	// zero value keeps collecting what the agent has always collected.
+	DisableTraceCollection bool
+	// DisableProcessMetrics disables the process scrapers of hostmetrics
+	DisableProcessMetrics bool
+	// DisableContainerMetrics disables the container receivers, e.g.
+	// docker_stats
+	DisableContainerMetrics        bool
+	DisableJournaldCollection      bool
+	DisableFluentforwardCollection bool
+}
+
+// HTTPClientConfig stores configuration for the HTTP client used for
+// the calls to the Middleware backend
+type HTTPClientConfig struct {
+	ProxyURL       string
+	NoProxy        string
+	CAFile         string
+	ClientCertFile string
+	ClientKeyFile  string
+	TLSMinVersion  string
+	Timeout        string
+}
+
+// String() implements stringer interface for HTTPClientConfig
+func (h HTTPClientConfig) String() string {
+	proxyURL := h.ProxyURL
+	if u, err := url.Parse(h.ProxyURL); err == nil {
+		// don't log the proxy credentials
+		proxyURL = u.Redacted()
+	}
+
+	var s string
+	s += fmt.Sprintf("proxy-url: %s, ", proxyURL)
+	s += fmt.Sprintf("no-proxy: %s, ", h.NoProxy)
+	s += fmt.Sprintf("ca-file: %s, ", h.CAFile)
+	s += fmt.Sprintf("client-cert-file: %s, ", h.ClientCertFile)
+	s += fmt.Sprintf("client-key-file: %s, ", h.ClientKeyFile)
+	s += fmt.Sprintf("tls-min-version: %s, ", h.TLSMinVersion)
+	s += fmt.Sprintf("timeout: %s", h.Timeout)
+	return s
 }
 
 // BaseConfig stores general configuration for all agent types
 type BaseConfig struct {
 	APIKey                       string
+	APIKeyMode                   string
 	Target                       string
 	EnableSyntheticMonitoring    bool
 	ConfigCheckInterval          string
@@ -91,12 +188,21 @@ type BaseConfig struct {
 	SelfProfiling                bool
 	ProfilngServerURL            string
 	InternalMetricsPort          uint
+	HTTPClient                   HTTPClientConfig
+
+	// ConfigSource is the source of the otel config, Middleware backend if
+	// empty. See NewConfigSource for the supported sources.
+	ConfigSource string
+	// ConfigPublicKey is the pinned ed25519 public key (PEM or base64) which
+	// must have signed the otel configs. Empty accepts unsigned configs.
+	ConfigPublicKey string
 }
 
 // String() implements stringer interface for BaseConfig
 func (c BaseConfig) String() string {
 	var s string
-	s += fmt.Sprintf("api-key: %s, ", c.APIKey)
+	s += fmt.Sprintf("api-key: %s, ", maskSecret(c.APIKey))
+	s += fmt.Sprintf("api-key-mode: %s, ", c.APIKeyMode)
 	s += fmt.Sprintf("target: %s, ", c.Target)
 	s += fmt.Sprintf("enable-synthetic-monitoring: %t, ", c.EnableSyntheticMonitoring)
 	s += fmt.Sprintf("config-check-interval: %s, ", c.ConfigCheckInterval)
@@ -105,6 +211,9 @@ func (c BaseConfig) String() string {
 	s += fmt.Sprintf("infra-platform: %s, ", c.InfraPlatform)
 	s += fmt.Sprintf("agent-features: %#v, ", c.AgentFeatures)
 	s += fmt.Sprintf("fluent-port: %#v, ", c.FluentPort)
+	s += fmt.Sprintf("http-client: {%s}, ", c.HTTPClient)
+	s += fmt.Sprintf("config-source: %s, ", redactConfigSource(c.ConfigSource))
+	s += fmt.Sprintf("config-signatures: %t, ", c.ConfigPublicKey != "")
 	return s
 }
 
@@ -112,10 +221,33 @@ func (c BaseConfig) String() string {
 type HostConfig struct {
 	BaseConfig
 
-	HostTags     string
-	Logfile      string
-	LogfileSize  int
-	LoggingLevel string
+	HostTags             string
+	Logfile              string
+	LogfileSize          int
+	LoggingLevel         string
+	ConfigHistorySize    int
+	ConfigRollbackWindow string
+
+	// OfflineBundle is the directory of a signed local config bundle. If set,
+	// the agent doesn't fetch the otel config from Middleware backend.
+	OfflineBundle          string
+	OfflineBundlePublicKey string
+
+	// StatusAddress is the loopback host:port or unix:// socket on which the
+	// agent serves its status. Empty disables the status server.
+	StatusAddress string
+
+	// ConfigStream enables the config changes pushed by the backend over a
+	// long-lived connection in addition to polling.
+	ConfigStream bool
+
+	// PolicyFile is the YAML file of the local policy which constrains the
+	// otel configs applied by the agent. Empty disables the policy.
+	PolicyFile string
+
+	// CloudMetadata enables the queries to the metadata service of the
+	// cloud the agent runs on, to identify the host by its instance.
+	CloudMetadata bool
 }
 
 // String() implements stringer interface for HostConfig
@@ -123,7 +255,14 @@ func (h HostConfig) String() string {
 	s := h.BaseConfig.String()
 	s += fmt.Sprintf("host-tags: %s, ", h.HostTags)
 	s += fmt.Sprintf("logfile: %s, ", h.Logfile)
-	s += fmt.Sprintf("logfile-size: %d", h.LogfileSize)
+	s += fmt.Sprintf("logfile-size: %d, ", h.LogfileSize)
+	s += fmt.Sprintf("config-history-size: %d, ", h.ConfigHistorySize)
+	s += fmt.Sprintf("config-rollback-window: %s, ", h.ConfigRollbackWindow)
+	s += fmt.Sprintf("offline-bundle: %s, ", h.OfflineBundle)
+	s += fmt.Sprintf("status-address: %s, ", h.StatusAddress)
+	s += fmt.Sprintf("config-stream: %t, ", h.ConfigStream)
+	s += fmt.Sprintf("policy-file: %s, ", h.PolicyFile)
+	s += fmt.Sprintf("cloud-metadata: %t", h.CloudMetadata)
 	return s
 }
 
@@ -147,6 +286,22 @@ func WithKubeAgentMonitorVersion(v string) KubeAgentMonitorOptions {
 	}
 }
 
+// WithKubeAgentMonitorConfigSource sets the source of the otel configs
+// written to the configmaps
+func WithKubeAgentMonitorConfigSource(source ConfigSource) KubeAgentMonitorOptions {
+	return func(k *KubeAgentMonitor) {
+		k.configSource = source
+	}
+}
+
+// WithKubeAgentMonitorHTTPClient sets the HTTP client for the calls to
+// the Middleware backend
+func WithKubeAgentMonitorHTTPClient(client *http.Client) KubeAgentMonitorOptions {
+	return func(k *KubeAgentMonitor) {
+		k.httpClient = client
+	}
+}
+
 // WithKubeAgentMonitorClusterName sets the cluster name
 func WithKubeAgentMonitorClusterName(v string) KubeAgentMonitorOptions {
 	return func(k *KubeAgentMonitor) {
@@ -0,0 +1,229 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// diagnoseTimeout bounds each network check run by Diagnose
+const diagnoseTimeout = 10 * time.Second
+
+// runningAgentTimeout bounds the query of the status server of a running
+// agent
+const runningAgentTimeout = 2 * time.Second
+
+// Diagnostic check results
+const (
+	CheckOK   = "ok"
+	CheckWarn = "warn"
+	CheckFail = "fail"
+)
+
+// CheckResult is the result of a single diagnostic check.
+type CheckResult struct {
+	Name    string
+	Result  string
+	Message string
+}
+
+// DiagnosticsFailed returns true if any of the given checks failed.
+func DiagnosticsFailed(results []CheckResult) bool {
+	for _, r := range results {
+		if r.Result == CheckFail {
+			return true
+		}
+	}
+	return false
+}
+
+func checkResult(name string, err error) CheckResult {
+	if err != nil {
+		return CheckResult{Name: name, Result: CheckFail, Message: err.Error()}
+	}
+	return CheckResult{Name: name, Result: CheckOK}
+}
+
+// Diagnose checks that the agent can run with its config on this host
+// without needing a running agent. It checks the target and the Middleware
+// backend are reachable and accept the API key, the docker endpoint and
+// that the ports used by the agent are free. If the status server of a
+// running agent answers, the ports are expected to be in use by that agent.
+func (c *HostAgent) Diagnose(ctx context.Context) []CheckResult {
+	var results []CheckResult
+
+	if c.APIKey == "" {
+		results = append(results, checkResult("api key", errors.New("api key is not set")))
+	} else {
+		results = append(results, CheckResult{Name: "api key", Result: CheckOK,
+			Message: maskSecret(c.APIKey)})
+	}
+
+	apiURL := c.APIURLForConfigCheck
+	if apiURL == "" {
+		var err error
+		apiURL, err = GetAPIURLForConfigCheck(c.Target)
+		if err != nil {
+			err = fmt.Errorf("failed to derive api url from target %q: %w", c.Target, err)
+		}
+		result := checkResult("api url", err)
+		if err == nil {
+			result.Message = apiURL
+		}
+		results = append(results, result)
+	}
+
+	results = append(results, c.checkTargetReachable(ctx))
+
+	if apiURL != "" && c.APIKey != "" {
+		cfg := c.BaseConfig
+		cfg.APIURLForConfigCheck = apiURL
+		results = append(results, checkResult("backend api", cfg.checkBackendAPI(ctx, c.httpDoFunc)))
+	}
+
+	results = append(results, c.checkDockerEndpoint())
+
+	agentRunning := c.agentRunning(ctx)
+	for _, port := range []struct {
+		name string
+		port string
+	}{
+		{"grpc port", c.GRPCPort},
+		{"http port", c.HTTPPort},
+		{"fluent port", c.FluentPort},
+		{"internal metrics port", fmt.Sprint(c.InternalMetricsPort)},
+	} {
+		results = append(results, addressResult(port.name, port.port,
+			checkPortFree(port.port), agentRunning))
+	}
+
+	if c.StatusAddress != "" && !strings.HasPrefix(c.StatusAddress, unixSocketPrefix) {
+		results = append(results, addressResult("status address", c.StatusAddress,
+			checkAddressFree(c.StatusAddress), agentRunning))
+	}
+
+	return results
+}
+
+// agentRunning checks whether an agent is running on this host, i.e. its
+// status server answers.
+func (c *HostAgent) agentRunning(ctx context.Context) bool {
+	if c.StatusAddress == "" {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, runningAgentTimeout)
+	defer cancel()
+
+	_, err := NewStatusClient(c.StatusAddress).Status(ctx)
+	return err == nil
+}
+
+// addressResult returns the result of the check that address is free. A
+// busy address doesn't fail the check while the agent is running, as the
+// agent listens on it.
+func addressResult(name string, address string, err error, agentRunning bool) CheckResult {
+	if err != nil && agentRunning {
+		return CheckResult{Name: name, Result: CheckOK,
+			Message: fmt.Sprintf("%s is in use by the running mw-agent", address)}
+	}
+
+	result := checkResult(name, err)
+	if result.Result == CheckOK {
+		result.Message = address
+	}
+	return result
+}
+
+// checkTargetReachable checks that a TCP connection can be made to the
+// target to which the collector exports the telemetry data.
+func (c *HostAgent) checkTargetReachable(ctx context.Context) CheckResult {
+	u, err := url.Parse(c.Target)
+	if err != nil || u.Hostname() == "" {
+		return checkResult("target", fmt.Errorf("invalid target %q", c.Target))
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = "443"
+	}
+
+	dialer := net.Dialer{Timeout: diagnoseTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return checkResult("target", fmt.Errorf("failed to connect to %s: %w", c.Target, err))
+	}
+	conn.Close()
+
+	return CheckResult{Name: "target", Result: CheckOK, Message: c.Target}
+}
+
+// checkBackendAPI calls the restart status api once to check that the
+// Middleware backend is reachable and accepts the API key.
+func (c BaseConfig) checkBackendAPI(ctx context.Context,
+	do func(req *http.Request) (*http.Response, error)) error {
+	u, err := c.apiURL(apiPathForRestart)
+	if err != nil {
+		return err
+	}
+
+	params := url.Values{}
+	params.Add("host_id", getHostname())
+	u.RawQuery = params.Encode()
+
+	ctx, cancel := context.WithTimeout(ctx, diagnoseTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	c.setAPIKey(req)
+
+	resp, err := do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call %s: %w", c.redactURL(u.String()), c.redactError(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return statusError("restart status api", resp.StatusCode)
+	}
+	return nil
+}
+
+// checkDockerEndpoint checks that the docker endpoint is a unix socket. A
+// missing socket is only a warning as the agent runs without docker.
+func (c *HostAgent) checkDockerEndpoint() CheckResult {
+	result := CheckResult{Name: "docker endpoint", Message: c.DockerEndpoint}
+	if c.getConfigType() == "docker" {
+		result.Result = CheckOK
+		return result
+	}
+
+	result.Result = CheckWarn
+	result.Message = fmt.Sprintf("%s is not a unix socket, docker monitoring is disabled",
+		c.DockerEndpoint)
+	return result
+}
+
+// checkPortFree checks that nothing is listening on the given local port.
+func checkPortFree(port string) error {
+	if port == "" {
+		return nil
+	}
+	return checkAddressFree(net.JoinHostPort("", port))
+}
+
+func checkAddressFree(address string) error {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("%s is not available (is mw-agent already running?): %w", address, err)
+	}
+	return l.Close()
+}
@@ -0,0 +1,124 @@
+package agent
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+func findCheck(t *testing.T, results []CheckResult, name string) CheckResult {
+	for _, r := range results {
+		if r.Name == name {
+			return r
+		}
+	}
+	t.Fatalf("check %s not found", name)
+	return CheckResult{}
+}
+
+func TestDiagnose(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer goodkey" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"status":true}`))
+	}))
+	defer server.Close()
+
+	// keep a port busy to check that it is reported
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	_, busyPort, err := net.SplitHostPort(l.Addr().String())
+	require.NoError(t, err)
+
+	cfg := HostConfig{
+		BaseConfig: BaseConfig{
+			APIKey:               "goodkey",
+			APIKeyMode:           APIKeyModeHeader,
+			Target:               server.URL,
+			APIURLForConfigCheck: server.URL,
+			DockerEndpoint:       "unix:///nonexistent/docker.sock",
+			GRPCPort:             busyPort,
+		},
+	}
+
+	agent, err := NewHostAgent(cfg, zapcore.NewNopCore())
+	require.NoError(t, err)
+
+	results := agent.Diagnose(context.Background())
+	assert.Equal(t, CheckOK, findCheck(t, results, "api key").Result)
+	assert.Equal(t, CheckOK, findCheck(t, results, "target").Result)
+	assert.Equal(t, CheckOK, findCheck(t, results, "backend api").Result)
+	assert.Equal(t, CheckWarn, findCheck(t, results, "docker endpoint").Result)
+	assert.Equal(t, CheckFail, findCheck(t, results, "grpc port").Result)
+	assert.Equal(t, CheckOK, findCheck(t, results, "http port").Result)
+	assert.True(t, DiagnosticsFailed(results))
+
+	cfg.APIKey = "badkey"
+	cfg.GRPCPort = ""
+	agent, err = NewHostAgent(cfg, zapcore.NewNopCore())
+	require.NoError(t, err)
+
+	results = agent.Diagnose(context.Background())
+	backend := findCheck(t, results, "backend api")
+	assert.Equal(t, CheckFail, backend.Result)
+	assert.True(t, strings.Contains(backend.Message, ErrAuthRevoked.Error()))
+	assert.True(t, DiagnosticsFailed(results))
+
+	// the api url is derived from an invalid target
+	cfg.APIURLForConfigCheck = ""
+	cfg.Target = "invalid"
+	agent, err = NewHostAgent(cfg, zapcore.NewNopCore())
+	require.NoError(t, err)
+
+	results = agent.Diagnose(context.Background())
+	assert.Equal(t, CheckFail, findCheck(t, results, "api url").Result)
+	assert.Equal(t, CheckFail, findCheck(t, results, "target").Result)
+}
+
+func TestDiagnoseRunningAgent(t *testing.T) {
+	// the ports of a running agent are busy
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	_, busyPort, err := net.SplitHostPort(l.Addr().String())
+	require.NoError(t, err)
+
+	runningAgent, err := NewHostAgent(HostConfig{}, zapcore.NewNopCore())
+	require.NoError(t, err)
+	s, err := NewStatusServer(runningAgent, "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = s.Serve()
+	}()
+	defer s.Close()
+
+	agent, err := NewHostAgent(HostConfig{
+		BaseConfig: BaseConfig{
+			GRPCPort: busyPort,
+		},
+		StatusAddress: s.Addr().String(),
+	}, zapcore.NewNopCore())
+	require.NoError(t, err)
+
+	results := agent.Diagnose(context.Background())
+	for _, name := range []string{"grpc port", "status address"} {
+		result := findCheck(t, results, name)
+		assert.Equal(t, CheckOK, result.Result, name)
+		assert.Contains(t, result.Message, "in use by the running mw-agent", name)
+	}
+
+	// without a running agent, the busy port fails the check
+	require.NoError(t, s.Close())
+	results = agent.Diagnose(context.Background())
+	assert.Equal(t, CheckFail, findCheck(t, results, "grpc port").Result)
+}
@@ -1,5 +1,218 @@
 package agent
 
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// ecsMetadataURIEnv is set by ECS to the task metadata endpoint (v4) of
+	// the container
+	ecsMetadataURIEnv    = "ECS_CONTAINER_METADATA_URI_V4"
+	ecsMetadataTimeout   = 5 * time.Second
+	ecsResourceProcessor = "resource/ecs"
+	// memoryLimiterProcessor is the type of the processor which must come
+	// first in every pipeline
+	memoryLimiterProcessor = "memory_limiter"
+)
+
+// ecsFargateUnsupportedReceivers are the receivers which can't collect
+// metrics on Fargate as there is neither a host nor a docker socket.
+var ecsFargateUnsupportedReceivers = []string{"hostmetrics", "docker_stats"}
+
+// ecsTaskMetadata is the response of the task metadata endpoint (v4).
+type ecsTaskMetadata struct {
+	Cluster          string `json:"Cluster"`
+	TaskARN          string `json:"TaskARN"`
+	Family           string `json:"Family"`
+	Revision         string `json:"Revision"`
+	AvailabilityZone string `json:"AvailabilityZone"`
+	LaunchType       string `json:"LaunchType"`
+}
+
+// ecsContainerMetadata is the response of the container metadata endpoint
+// (v4).
+type ecsContainerMetadata struct {
+	DockerID     string `json:"DockerId"`
+	Name         string `json:"Name"`
+	Image        string `json:"Image"`
+	ContainerARN string `json:"ContainerARN"`
+}
+
+// ecsAttributes are the resource attributes of the ECS task which runs the
+// agent.
+type ecsAttributes struct {
+	// task are the attributes of the task and the cluster
+	task map[string]string
+	// container are the attributes of the agent container, which are only
+	// set on telemetry which has no container attributes yet
+	container map[string]string
+}
+
+// fetchECSMetadata reads the ECS task and container metadata from the
+// task metadata endpoint (v4).
+func fetchECSMetadata(ctx context.Context, uri string) (*ecsTaskMetadata, *ecsContainerMetadata, error) {
+	// the task metadata endpoint is link-local, so it is never proxied
+	client := &http.Client{
+		Timeout:   ecsMetadataTimeout,
+		Transport: &http.Transport{Proxy: nil},
+	}
+
+	get := func(url string, v interface{}) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("ecs metadata endpoint returned status: %d", resp.StatusCode)
+		}
+
+		return json.NewDecoder(resp.Body).Decode(v)
+	}
+
+	uri = strings.TrimSuffix(uri, "/")
+
+	var task ecsTaskMetadata
+	if err := get(uri+"/task", &task); err != nil {
+		return nil, nil, err
+	}
+
+	var container ecsContainerMetadata
+	if err := get(uri, &container); err != nil {
+		return nil, nil, err
+	}
+
+	return &task, &container, nil
+}
+
+// newECSAttributes returns the resource attributes for the given metadata
+// as per the otel semantic conventions.
+func newECSAttributes(task *ecsTaskMetadata, container *ecsContainerMetadata) *ecsAttributes {
+	attrs := &ecsAttributes{
+		task: map[string]string{
+			"cloud.provider": "aws",
+			"cloud.platform": "aws_ecs",
+		},
+		container: map[string]string{},
+	}
+
+	set := func(m map[string]string, key, value string) {
+		if value != "" {
+			m[key] = value
+		}
+	}
+
+	// arn:aws:ecs:<region>:<account>:task/<cluster>/<id>
+	arn := strings.Split(task.TaskARN, ":")
+	region, account := "", ""
+	if len(arn) >= 6 {
+		region, account = arn[3], arn[4]
+	}
+
+	cluster := task.Cluster
+	if cluster != "" && !strings.HasPrefix(cluster, "arn:") && region != "" {
+		cluster = fmt.Sprintf("arn:aws:ecs:%s:%s:cluster/%s", region, account, cluster)
+	}
+
+	set(attrs.task, "cloud.region", region)
+	set(attrs.task, "cloud.account.id", account)
+	set(attrs.task, "cloud.availability_zone", task.AvailabilityZone)
+	set(attrs.task, "aws.ecs.cluster.arn", cluster)
+	set(attrs.task, "aws.ecs.task.arn", task.TaskARN)
+	set(attrs.task, "aws.ecs.task.family", task.Family)
+	set(attrs.task, "aws.ecs.task.revision", task.Revision)
+	set(attrs.task, "aws.ecs.launchtype", strings.ToLower(task.LaunchType))
+
+	// the tag is after the last colon unless it is the port of the registry
+	imageName, imageTag := container.Image, ""
+	if i := strings.LastIndex(imageName, ":"); i > strings.LastIndex(imageName, "/") {
+		imageName, imageTag = imageName[:i], imageName[i+1:]
+	}
+
+	set(attrs.container, "aws.ecs.container.arn", container.ContainerARN)
+	set(attrs.container, "container.id", container.DockerID)
+	set(attrs.container, "container.name", container.Name)
+	set(attrs.container, "container.image.name", imageName)
+	set(attrs.container, "container.image.tag", imageTag)
+
+	return attrs
+}
+
+// processorConfig returns the config of the resource processor which adds
+// the attributes.
+func (a *ecsAttributes) processorConfig() map[string]interface{} {
+	var actions []interface{}
+	add := func(attrs map[string]string, action string) {
+		keys := make([]string, 0, len(attrs))
+		for key := range attrs {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			actions = append(actions, map[string]interface{}{
+				"key":    key,
+				"value":  attrs[key],
+				"action": action,
+			})
+		}
+	}
+
+	add(a.task, "upsert")
+	add(a.container, "insert")
+
+	return map[string]interface{}{"attributes": actions}
+}
+
+// getECSAttributes returns the resource attributes of the ECS task. The
+// task metadata doesn't change during the life of the task, so it is
+// fetched once. It returns nil if the metadata is not available.
+func (c *HostAgent) getECSAttributes() *ecsAttributes {
+	c.ecsMu.Lock()
+	defer c.ecsMu.Unlock()
+
+	if c.ecsAttributes != nil {
+		return c.ecsAttributes
+	}
+
+	uri := os.Getenv(ecsMetadataURIEnv)
+	if uri == "" {
+		c.logger.Debug("ecs task metadata endpoint is not set", zap.String("env", ecsMetadataURIEnv))
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ecsMetadataTimeout)
+	defer cancel()
+
+	task, container, err := fetchECSMetadata(ctx, uri)
+	if err != nil {
+		c.logger.Warn("failed to fetch ecs task metadata", zap.Error(err))
+		return nil
+	}
+
+	c.ecsAttributes = newECSAttributes(task, container)
+	return c.ecsAttributes
+}
+
+// updateConfigForECS adds the awsecscontainermetrics receiver to the
+// metrics pipeline and the resource attributes of the ECS task to every
+// pipeline. On Fargate, the receivers which can't run there are removed.
 func (c *HostAgent) updateConfigForECS(config map[string]interface{}) (map[string]interface{}, error) {
 
 	receiverData, ok := config[Receivers].(map[string]interface{})
@@ -7,8 +220,6 @@ func (c *HostAgent) updateConfigForECS(config map[string]interface{}) (map[strin
 		return nil, ErrParseReceivers
 	}
 
-	receiverData[AWSECSContainerMetrics] = map[string]interface{}{}
-
 	serviceData, ok := config[Service].(map[string]interface{})
 	if !ok {
 		return nil, ErrParseService
@@ -19,22 +230,80 @@ func (c *HostAgent) updateConfigForECS(config map[string]interface{}) (map[strin
 		return nil, ErrParsePipelines
 	}
 
-	metricsData, ok := pipelinesData[Metrics].(map[string]interface{})
-	if !ok {
-		return nil, ErrParseMetrics
+	// the container metrics go to the metrics pipeline, or the first
+	// metrics/<name> pipeline if there is none, as the names sort so
+	var metricsPipelines []string
+	for name := range pipelinesData {
+		if pipelineType, _, _ := strings.Cut(name, "/"); pipelineType == Metrics {
+			metricsPipelines = append(metricsPipelines, name)
+		}
 	}
+	sort.Strings(metricsPipelines)
 
-	receiversData := []string{}
+	if len(metricsPipelines) == 0 {
+		c.logger.Warn("no metrics pipeline in otel config, ecs container metrics are not collected")
+	} else {
+		metricsData, ok := pipelinesData[metricsPipelines[0]].(map[string]interface{})
+		if !ok {
+			return nil, ErrParseMetrics
+		}
 
-	if c.InfraPlatform == InfraPlatformECSEC2 {
-		for _, receiver := range metricsData[Receivers].([]interface{}) {
-			receiverName := receiver.(string)
-			receiversData = append(receiversData, receiverName)
+		receiverData[AWSECSContainerMetrics] = map[string]interface{}{}
+		receivers := componentNames(metricsData[Receivers])
+		if !slices.Contains(receivers, AWSECSContainerMetrics) {
+			receivers = append(receivers, AWSECSContainerMetrics)
 		}
+		metricsData[Receivers] = receivers
 	}
 
-	receiversData = append(receiversData, AWSECSContainerMetrics)
-	metricsData[Receivers] = receiversData
+	if c.InfraPlatform == InfraPlatformECSFargate {
+		removed := map[string]struct{}{}
+		for name := range receiverData {
+			receiverType, _, _ := strings.Cut(name, "/")
+			if slices.Contains(ecsFargateUnsupportedReceivers, receiverType) {
+				delete(receiverData, name)
+				removed[name] = struct{}{}
+			}
+		}
+		removeComponentReferences(config, removed)
+	}
+
+	if attrs := c.getECSAttributes(); attrs != nil {
+		prependProcessor(config, ecsResourceProcessor, attrs.processorConfig())
+	}
 
 	return config, nil
 }
+
+// prependProcessor adds the given processor to config and puts it first in
+// every pipeline, so that the processors after it see its changes. Only a
+// memory_limiter stays before it, as it must be the first processor.
+func prependProcessor(config map[string]interface{}, name string, processorConfig interface{}) {
+	processorsData, ok := config["processors"].(map[string]interface{})
+	if !ok {
+		processorsData = map[string]interface{}{}
+		config["processors"] = processorsData
+	}
+	processorsData[name] = processorConfig
+
+	for _, value := range configSection(config, Service, Pipelines) {
+		pipeline, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		processors := componentNames(pipeline["processors"])
+		if !slices.Contains(processors, name) {
+			position := 0
+			for i, processor := range processors {
+				processorType, _, _ := strings.Cut(processor, "/")
+				if processorType == memoryLimiterProcessor {
+					position = i + 1
+					break
+				}
+			}
+			processors = slices.Insert(processors, position, name)
+		}
+		pipeline["processors"] = processors
+	}
+}
@@ -0,0 +1,224 @@
+package agent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+const ecsTestConfig = `receivers:
+  otlp:
+    protocols:
+      grpc:
+        endpoint: 127.0.0.1:0
+  hostmetrics:
+    scrapers:
+      cpu: {}
+  filelog:
+    include: [/var/log/app.log]
+processors:
+  batch: {}
+exporters:
+  otlp:
+    endpoint: example.com:443
+service:
+  pipelines:
+    metrics/hostmetrics:
+      receivers: [hostmetrics]
+      exporters: [otlp]
+    metrics/otlp:
+      receivers: [otlp]
+      processors: [batch]
+      exporters: [otlp]
+    logs:
+      receivers: [filelog]
+      exporters: [otlp]
+    traces:
+      receivers: [otlp]
+      processors: [batch]
+      exporters: [otlp]
+`
+
+// newECSMetadataServer serves the ECS task metadata endpoint (v4) and
+// counts the requests.
+func newECSMetadataServer(t *testing.T, requests *int) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		switch r.URL.Path {
+		case "/v4/abc/task":
+			_, _ = w.Write([]byte(`{
+				"Cluster": "prod",
+				"TaskARN": "arn:aws:ecs:us-west-2:111122223333:task/prod/abc",
+				"Family": "web",
+				"Revision": "7",
+				"AvailabilityZone": "us-west-2a",
+				"LaunchType": "FARGATE"
+			}`))
+		case "/v4/abc":
+			_, _ = w.Write([]byte(`{
+				"DockerId": "abc-123",
+				"Name": "mw-agent",
+				"Image": "registry.example.com:5000/mw-agent:1.2.3",
+				"ContainerARN": "arn:aws:ecs:us-west-2:111122223333:container/prod/abc/def"
+			}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newECSAgent(t *testing.T, platform InfraPlatform) *HostAgent {
+	agent, err := NewHostAgent(HostConfig{}, zapcore.NewNopCore(),
+		WithHostAgentInfraPlatform(platform))
+	require.NoError(t, err)
+	return agent
+}
+
+func TestUpdateConfigForECS(t *testing.T) {
+	requests := 0
+	server := newECSMetadataServer(t, &requests)
+	t.Setenv(ecsMetadataURIEnv, server.URL+"/v4/abc")
+
+	tests := []struct {
+		platform      InfraPlatform
+		wantReceivers map[string][]string
+	}{
+		{
+			platform: InfraPlatformECSEC2,
+			wantReceivers: map[string][]string{
+				"metrics/hostmetrics": {"hostmetrics", AWSECSContainerMetrics},
+				"metrics/otlp":        {"otlp"},
+				"logs":                {"filelog"},
+				"traces":              {"otlp"},
+			},
+		},
+		{
+			// hostmetrics can't run on Fargate, the other receivers are kept
+			platform: InfraPlatformECSFargate,
+			wantReceivers: map[string][]string{
+				"metrics/hostmetrics": {AWSECSContainerMetrics},
+				"metrics/otlp":        {"otlp"},
+				"logs":                {"filelog"},
+				"traces":              {"otlp"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.platform.String(), func(t *testing.T) {
+			config, err := parseConfig([]byte(ecsTestConfig))
+			require.NoError(t, err)
+
+			config, err = newECSAgent(t, tt.platform).updateConfigForECS(config)
+			require.NoError(t, err)
+
+			receivers := map[string][]string{}
+			for name, value := range configSection(config, Service, Pipelines) {
+				pipeline := value.(map[string]interface{})
+				receivers[name] = componentNames(pipeline[Receivers])
+
+				// every pipeline gets the ecs resource attributes first
+				assert.Equal(t, ecsResourceProcessor, componentNames(pipeline["processors"])[0], name)
+			}
+			assert.Equal(t, tt.wantReceivers, receivers)
+			assert.Contains(t, config[Receivers], AWSECSContainerMetrics)
+		})
+	}
+
+	config, err := parseConfig([]byte(ecsTestConfig))
+	require.NoError(t, err)
+	agent := newECSAgent(t, InfraPlatformECSFargate)
+	requests = 0
+	_, err = agent.updateConfigForECS(config)
+	require.NoError(t, err)
+
+	attributes := map[string]string{}
+	for _, action := range configSection(config, "processors", ecsResourceProcessor)["attributes"].([]interface{}) {
+		action := action.(map[string]interface{})
+		attributes[action["key"].(string)] = action["action"].(string) + ":" + action["value"].(string)
+	}
+	assert.Equal(t, map[string]string{
+		"cloud.provider":          "upsert:aws",
+		"cloud.platform":          "upsert:aws_ecs",
+		"cloud.region":            "upsert:us-west-2",
+		"cloud.account.id":        "upsert:111122223333",
+		"cloud.availability_zone": "upsert:us-west-2a",
+		"aws.ecs.cluster.arn":     "upsert:arn:aws:ecs:us-west-2:111122223333:cluster/prod",
+		"aws.ecs.task.arn":        "upsert:arn:aws:ecs:us-west-2:111122223333:task/prod/abc",
+		"aws.ecs.task.family":     "upsert:web",
+		"aws.ecs.task.revision":   "upsert:7",
+		"aws.ecs.launchtype":      "upsert:fargate",
+		"aws.ecs.container.arn":   "insert:arn:aws:ecs:us-west-2:111122223333:container/prod/abc/def",
+		"container.id":            "insert:abc-123",
+		"container.name":          "insert:mw-agent",
+		"container.image.name":    "insert:registry.example.com:5000/mw-agent",
+		"container.image.tag":     "insert:1.2.3",
+	}, attributes)
+
+	// the metadata is fetched once
+	config, err = parseConfig([]byte(ecsTestConfig))
+	require.NoError(t, err)
+	_, err = agent.updateConfigForECS(config)
+	require.NoError(t, err)
+	assert.Equal(t, 2, requests)
+}
+
+func TestUpdateConfigForECSWithoutMetadata(t *testing.T) {
+	t.Setenv(ecsMetadataURIEnv, "")
+
+	// a config without metrics pipeline is not rejected
+	config, err := parseConfig([]byte(`receivers:
+  otlp: {}
+service:
+  pipelines:
+    traces:
+      receivers: [otlp]
+      exporters: [otlp]
+`))
+	require.NoError(t, err)
+
+	config, err = newECSAgent(t, InfraPlatformECSFargate).updateConfigForECS(config)
+	require.NoError(t, err)
+	assert.NotContains(t, config[Receivers], AWSECSContainerMetrics)
+	assert.NotContains(t, config, "processors")
+
+	// the metrics pipeline keeps its receivers on EC2
+	config, err = parseConfig([]byte(ecsTestConfig))
+	require.NoError(t, err)
+	config, err = newECSAgent(t, InfraPlatformECSEC2).updateConfigForECS(config)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"hostmetrics", AWSECSContainerMetrics},
+		componentNames(configSection(config, Service, Pipelines, "metrics/hostmetrics")[Receivers]))
+}
+
+func TestPrependProcessor(t *testing.T) {
+	config, err := parseConfig([]byte(`service:
+  pipelines:
+    metrics:
+      processors: [memory_limiter, batch]
+    logs:
+      processors: [memory_limiter/logs, batch]
+    traces:
+      processors: [batch]
+`))
+	require.NoError(t, err)
+
+	prependProcessor(config, ecsResourceProcessor, map[string]interface{}{})
+
+	// memory_limiter stays the first processor
+	pipelines := configSection(config, Service, Pipelines)
+	for name, want := range map[string][]string{
+		"metrics": {"memory_limiter", ecsResourceProcessor, "batch"},
+		"logs":    {"memory_limiter/logs", ecsResourceProcessor, "batch"},
+		"traces":  {ecsResourceProcessor, "batch"},
+	} {
+		pipeline := pipelines[name].(map[string]interface{})
+		assert.Equal(t, want, componentNames(pipeline["processors"]), name)
+	}
+}
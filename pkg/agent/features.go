@@ -0,0 +1,137 @@
+package agent
+
+import (
+	"slices"
+	"strings"
+)
+
+// featureToggle lists the otel components of a feature of the agent, which
+// are removed from the otel config when the feature is disabled.
+type featureToggle struct {
+	name    string
+	enabled func(AgentFeatures) bool
+	// pipelines are the types of the pipelines of the feature, e.g. logs
+	pipelines []string
+	// receivers are the types of the receivers of the feature. They are
+	// also removed from the pipelines of the other features.
+	receivers []string
+	// scrapers are the hostmetrics scrapers of the feature
+	scrapers []string
+}
+
+// featureToggles maps the features of the agent to their otel components.
+// The receivers which are only used by the removed pipelines are removed as
+// well, so the receivers missing here can't escape a disabled feature.
+var featureToggles = []featureToggle{
+	{
+		name:      "metric-collection",
+		enabled:   func(f AgentFeatures) bool { return f.MetricCollection },
+		pipelines: []string{"metrics"},
+		receivers: []string{"hostmetrics", "windowsperfcounters", "docker_stats",
+			"prometheus", "kubeletstats", "k8s_cluster", "awsecscontainermetrics",
+			"statsd", "kafkametrics"},
+	},
+	{
+		name:      "log-collection",
+		enabled:   func(f AgentFeatures) bool { return f.LogCollection },
+		pipelines: []string{"logs"},
+		receivers: []string{"filelog", "windowseventlog", "journald", "fluentforward"},
+	},
+	{
+		name:      "trace-collection",
+		enabled:   func(f AgentFeatures) bool { return !f.DisableTraceCollection },
+		pipelines: []string{"traces"},
+		receivers: []string{"jaeger", "zipkin"},
+	},
+	{
+		name:     "process-metrics",
+		enabled:  func(f AgentFeatures) bool { return !f.DisableProcessMetrics },
+		scrapers: []string{"process", "processes"},
+	},
+	{
+		name:      "container-metrics",
+		enabled:   func(f AgentFeatures) bool { return !f.DisableContainerMetrics },
+		receivers: []string{"docker_stats", "awsecscontainermetrics"},
+	},
+	{
+		name:      "journald-collection",
+		enabled:   func(f AgentFeatures) bool { return !f.DisableJournaldCollection },
+		receivers: []string{"journald"},
+	},
+	{
+		name:      "fluentforward-collection",
+		enabled:   func(f AgentFeatures) bool { return !f.DisableFluentforwardCollection },
+		receivers: []string{"fluentforward"},
+	},
+}
+
+// disabledToggles returns the toggles of the features disabled in f.
+func (f AgentFeatures) disabledToggles() []featureToggle {
+	var toggles []featureToggle
+	for _, toggle := range featureToggles {
+		if !toggle.enabled(f) {
+			toggles = append(toggles, toggle)
+		}
+	}
+	return toggles
+}
+
+// removeFeatures removes the components of the given features from config.
+func removeFeatures(config map[string]interface{}, toggles []featureToggle) {
+	receivers := configSection(config, Receivers)
+	pipelines := configSection(config, Service, Pipelines)
+	removed := map[string]struct{}{}
+	// orphans are the receivers of the removed pipelines
+	orphans := map[string]struct{}{}
+
+	for _, toggle := range toggles {
+		for name, value := range pipelines {
+			pipelineType, _, _ := strings.Cut(name, "/")
+			if slices.Contains(toggle.pipelines, pipelineType) {
+				pipeline, _ := value.(map[string]interface{})
+				for _, receiver := range componentNames(pipeline[Receivers]) {
+					orphans[receiver] = struct{}{}
+				}
+				delete(pipelines, name)
+			}
+		}
+
+		for name, receiverConfig := range receivers {
+			receiverType, _, _ := strings.Cut(name, "/")
+			if slices.Contains(toggle.receivers, receiverType) ||
+				(receiverType == "hostmetrics" && !removeScrapers(receiverConfig, toggle.scrapers)) {
+				delete(receivers, name)
+				removed[name] = struct{}{}
+			}
+		}
+	}
+
+	removeComponentReferences(config, removed)
+
+	// remove the receivers of the removed pipelines which are not used by
+	// the remaining ones
+	for _, value := range pipelines {
+		pipeline, _ := value.(map[string]interface{})
+		for _, name := range componentNames(pipeline[Receivers]) {
+			delete(orphans, name)
+		}
+	}
+	for name := range orphans {
+		delete(receivers, name)
+	}
+}
+
+// removeScrapers removes the given scrapers from the hostmetrics receiver
+// config. It returns false if the receiver is left without scrapers.
+func removeScrapers(receiverConfig interface{}, scrapers []string) bool {
+	receiver, _ := receiverConfig.(map[string]interface{})
+	configured, ok := receiver["scrapers"].(map[string]interface{})
+	if !ok || len(scrapers) == 0 {
+		return true
+	}
+
+	for _, scraper := range scrapers {
+		delete(configured, scraper)
+	}
+	return len(configured) > 0
+}
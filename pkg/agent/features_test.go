@@ -0,0 +1,151 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const featuresTestConfig = `receivers:
+  otlp:
+    protocols:
+      grpc:
+        endpoint: 127.0.0.1:0
+  hostmetrics:
+    scrapers:
+      cpu: {}
+      process: {}
+      processes: {}
+  hostmetrics/processes:
+    scrapers:
+      process: {}
+  docker_stats: {}
+  filelog/app:
+    include: [/var/log/app.log]
+  journald: {}
+  fluentforward:
+    endpoint: 127.0.0.1:8006
+  newmetrics: {}
+exporters:
+  otlp:
+    endpoint: example.com:443
+service:
+  pipelines:
+    metrics:
+      receivers: [hostmetrics, hostmetrics/processes, docker_stats, newmetrics]
+      exporters: [otlp]
+    logs:
+      receivers: [otlp, filelog/app, journald, fluentforward]
+      exporters: [otlp]
+    traces:
+      receivers: [otlp]
+      exporters: [otlp]
+`
+
+func TestRemoveFeatures(t *testing.T) {
+	// the other features are enabled by the zero value
+	allEnabled := AgentFeatures{
+		MetricCollection: true,
+		LogCollection:    true,
+	}
+
+	tests := []struct {
+		name          string
+		disable       func(f *AgentFeatures)
+		wantReceivers []string
+		wantPipelines map[string][]string
+	}{
+		{
+			name:    "metric collection",
+			disable: func(f *AgentFeatures) { f.MetricCollection = false },
+			// newmetrics is only used by the metrics pipeline
+			wantReceivers: []string{"filelog/app", "fluentforward", "journald", "otlp"},
+			wantPipelines: map[string][]string{
+				"logs":   {"otlp", "filelog/app", "journald", "fluentforward"},
+				"traces": {"otlp"},
+			},
+		},
+		{
+			name:    "log collection",
+			disable: func(f *AgentFeatures) { f.LogCollection = false },
+			wantReceivers: []string{"docker_stats", "hostmetrics", "hostmetrics/processes",
+				"newmetrics", "otlp"},
+			wantPipelines: map[string][]string{
+				"metrics": {"hostmetrics", "hostmetrics/processes", "docker_stats", "newmetrics"},
+				"traces":  {"otlp"},
+			},
+		},
+		{
+			name:    "trace collection",
+			disable: func(f *AgentFeatures) { f.DisableTraceCollection = true },
+			wantReceivers: []string{"docker_stats", "filelog/app", "fluentforward", "hostmetrics",
+				"hostmetrics/processes", "journald", "newmetrics", "otlp"},
+			wantPipelines: map[string][]string{
+				"metrics": {"hostmetrics", "hostmetrics/processes", "docker_stats", "newmetrics"},
+				"logs":    {"otlp", "filelog/app", "journald", "fluentforward"},
+			},
+		},
+		{
+			name: "process and container metrics",
+			disable: func(f *AgentFeatures) {
+				f.DisableProcessMetrics = true
+				f.DisableContainerMetrics = true
+			},
+			wantReceivers: []string{"filelog/app", "fluentforward", "hostmetrics", "journald",
+				"newmetrics", "otlp"},
+			wantPipelines: map[string][]string{
+				"metrics": {"hostmetrics", "newmetrics"},
+				"logs":    {"otlp", "filelog/app", "journald", "fluentforward"},
+				"traces":  {"otlp"},
+			},
+		},
+		{
+			name: "journald and fluentforward",
+			disable: func(f *AgentFeatures) {
+				f.DisableJournaldCollection = true
+				f.DisableFluentforwardCollection = true
+			},
+			wantReceivers: []string{"docker_stats", "filelog/app", "hostmetrics",
+				"hostmetrics/processes", "newmetrics", "otlp"},
+			wantPipelines: map[string][]string{
+				"metrics": {"hostmetrics", "hostmetrics/processes", "docker_stats", "newmetrics"},
+				"logs":    {"otlp", "filelog/app"},
+				"traces":  {"otlp"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := parseConfig([]byte(featuresTestConfig))
+			require.NoError(t, err)
+
+			features := allEnabled
+			tt.disable(&features)
+			removeFeatures(config, features.disabledToggles())
+
+			var receivers []string
+			for name := range configSection(config, Receivers) {
+				receivers = append(receivers, name)
+			}
+			assert.ElementsMatch(t, tt.wantReceivers, receivers)
+
+			pipelines := map[string][]string{}
+			for name, pipeline := range configSection(config, Service, Pipelines) {
+				pipelines[name] = componentNames(pipeline.(map[string]interface{})[Receivers])
+			}
+			assert.Equal(t, tt.wantPipelines, pipelines)
+		})
+	}
+
+	// the process scrapers are removed from the remaining hostmetrics
+	config, err := parseConfig([]byte(featuresTestConfig))
+	require.NoError(t, err)
+	features := allEnabled
+	features.DisableProcessMetrics = true
+	removeFeatures(config, features.disabledToggles())
+	assert.Equal(t, map[string]interface{}{"cpu": map[string]interface{}{}},
+		configSection(config, Receivers, "hostmetrics", "scrapers"))
+	assert.Empty(t, allEnabled.disabledToggles())
+}
@@ -3,6 +3,8 @@ package agent
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,6 +12,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
 	"runtime"
 	"strings"
@@ -27,11 +30,41 @@ import (
 	yaml "gopkg.in/yaml.v2"
 )
 
+// Errors sent by ListenForConfigChanges. The caller is expected to check
+// them with errors.Is and react to each of them differently.
 var (
-	ErrRestartAgent  = errors.New("restart agent due to config change")
+	// ErrRestartAgent asks for the collector to be restarted because its
+	// config has changed
+	ErrRestartAgent = errors.New("restart agent due to config change")
+	// ErrInvalidConfig is returned when the config from the backend fails
+	// validation. The collector should be kept in its current state.
 	ErrInvalidConfig = errors.New("invalid config received from backend")
+	// ErrTransient is returned when the backend can't be reached or keeps
+	// failing temporarily. The collector should be kept in its current state.
+	ErrTransient = errors.New("backend temporarily unavailable")
+	// ErrAuthRevoked is returned when the backend rejects the API key. The
+	// collector should be stopped until the backend accepts the key again.
+	ErrAuthRevoked = errors.New("api key rejected by backend")
+
+	// ErrConfigUnchanged is returned when the config from the config source
+	// is the same as the config the collector is running with. Config
+	// sources also return it when the config still has the requested ETag.
+	ErrConfigUnchanged = errors.New("config unchanged")
 )
 
+// statusError returns the error for an unexpected status code from the
+// given backend api, classified as ErrAuthRevoked or ErrTransient where
+// possible.
+func statusError(api string, statusCode int) error {
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return fmt.Errorf("%w: %s returned status: %d", ErrAuthRevoked, api, statusCode)
+	case isRetryableStatus(statusCode):
+		return fmt.Errorf("%w: %s returned status: %d", ErrTransient, api, statusCode)
+	}
+	return fmt.Errorf("%s returned non-200 status: %d", api, statusCode)
+}
+
 // HostAgent implements Agent interface for Hosts (e.g Linux)
 type HostAgent struct {
 	HostConfig
@@ -41,10 +74,82 @@ type HostAgent struct {
 	collectorWG        *sync.WaitGroup
 	zapCore            zapcore.Core
 	logger             *zap.Logger
-	httpGetFunc        func(url string) (resp *http.Response, err error)
+	httpDoFunc         func(req *http.Request) (resp *http.Response, err error)
 	Version            string
+
+	// collectorMu protects collector, which is nil if no collector is
+	// running. It is also held while starting a collector, so that only
+	// one collector runs at a time.
+	collectorMu sync.Mutex
+
+	// backoff controls the retries of failed backend API calls
+	backoff backoff
+
+	// configSource provides the otel config, Middleware backend by default
+	configSource ConfigSource
+
+	// policy is the local policy enforced on the otel config, nil if none
+	policy *Policy
+
+	// ecsAttributes are the resource attributes of the ECS task, nil until
+	// they are fetched from the task metadata endpoint
+	ecsMu         sync.Mutex
+	ecsAttributes *ecsAttributes
+
+	// cloudInstance is nil if the agent doesn't use the cloud metadata
+	cloudInstance *CloudInstance
+
+	// agentID is the persistent ID of the agent, empty if unknown
+	agentID string
+
+	// trackingMu serializes the tracking events so that they are sent in
+	// order. trackingQueue is nil if failed events are not queued.
+	trackingMu    sync.Mutex
+	trackingQueue *trackingQueue
+	// trackingChMu protects trackingCh, which holds the events tracked
+	// asynchronously until they are sent. It is created on the first event
+	// and closed by Close. trackingDone is closed once the events are
+	// handled, and trackingCancel gives up sending them to the backend.
+	trackingChMu   sync.Mutex
+	trackingCh     chan trackedEvent
+	trackingClosed bool
+	trackingDone   chan struct{}
+	trackingCancel context.CancelFunc
+
+	// streamDoFunc opens the config stream. Unlike httpDoFunc, it has no
+	// timeout as the stream is long-lived.
+	streamDoFunc  func(req *http.Request) (resp *http.Response, err error)
+	streamBackoff backoff
+
+	// configHistory is nil if config history is disabled
+	configHistory        *configHistory
+	configRollbackWindow time.Duration
+	// configMu protects pendingConfigVersion, configETags, configLoadedAt,
+	// stableTimer and failedConfigHash
+	configMu sync.Mutex
+	// pendingConfigVersion is the applied config version which is yet to
+	// prove that it can run the collector successfully
+	pendingConfigVersion string
+	// configETags maps the config type to the ETag of the last config
+	// applied from the backend
+	configETags map[string]string
+	// configLoadedAt is the time when the collector last loaded its config
+	configLoadedAt time.Time
+	// stableTimer marks the config as good once the collector has run
+	// with it for the rollback window
+	stableTimer *time.Timer
+	// failedConfigHash is the hash of the last config which was rolled back
+	// because the collector failed with it
+	failedConfigHash string
+
+	// state is served by the status server
+	state agentState
 }
 
+// defaultConfigRollbackWindow is the duration for which the collector needs
+// to run with a newly applied config before the config is considered good.
+const defaultConfigRollbackWindow = 2 * time.Minute
+
 // HostOptions takes in various options for HostAgent
 type HostOptions func(h *HostAgent)
 
@@ -70,18 +175,109 @@ func WithHostAgentInfraPlatform(p InfraPlatform) HostOptions {
 	}
 }
 
+// WithHostAgentConfigSource sets the source of the otel config. It takes
+// precedence over HostConfig.ConfigSource.
+func WithHostAgentConfigSource(source ConfigSource) HostOptions {
+	return func(h *HostAgent) {
+		h.configSource = source
+	}
+}
+
+// WithHostAgentCloudInstance sets the cloud VM the agent runs on. Its ID
+// is sent to the backend as host_id instead of the hostname.
+func WithHostAgentCloudInstance(instance *CloudInstance) HostOptions {
+	return func(h *HostAgent) {
+		h.cloudInstance = instance
+	}
+}
+
+// WithHostAgentID sets the persistent ID of the agent, see
+// LoadOrCreateAgentID. It is sent to the backend along with host_id.
+func WithHostAgentID(id string) HostOptions {
+	return func(h *HostAgent) {
+		h.agentID = id
+	}
+}
+
 // NewHostAgent returns new agent for Kubernetes with given options.
 func NewHostAgent(cfg HostConfig, zapCore zapcore.Core,
 	opts ...HostOptions) (*HostAgent, error) {
 	var agent HostAgent
 	agent.HostConfig = cfg
-	agent.httpGetFunc = http.Get
+	agent.backoff = defaultBackoff
 
 	for _, apply := range opts {
 		apply(&agent)
 	}
 
-	agent.logger = zap.New(zapCore, zap.AddCaller())
+	agent.logger = zap.New(zapCore, zap.AddCaller(), redactingOption(cfg.Secrets()...))
+
+	if err := cfg.ValidateAPIKeyMode(); err != nil {
+		return nil, err
+	}
+
+	if _, err := cfg.configPublicKey(); err != nil {
+		return nil, fmt.Errorf("invalid config public key: %w", err)
+	}
+
+	if cfg.PolicyFile != "" {
+		policy, err := LoadPolicy(cfg.PolicyFile)
+		if err != nil {
+			return nil, err
+		}
+		agent.policy = policy
+	}
+
+	httpClient, err := NewHTTPClient(cfg.HTTPClient)
+	if err != nil {
+		return nil, err
+	}
+	agent.httpDoFunc = httpClient.Do
+
+	if agent.configSource == nil {
+		agent.configSource, err = NewConfigSource(cfg.ConfigSource, httpClient.Do)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if agent.configSource == nil {
+		agent.configSource = &hostAPIConfigSource{agent: &agent}
+	}
+
+	if cfg.ConfigStream && !agent.hasAPIConfigSource() {
+		agent.logger.Warn("config stream is only supported with Middleware backend as config source",
+			zap.Stringer("config-source", agent.configSource))
+	} else if cfg.ConfigStream {
+		streamClientConfig := cfg.HTTPClient
+		streamClientConfig.Timeout = "0s"
+		streamClient, err := NewHTTPClient(streamClientConfig)
+		if err != nil {
+			return nil, err
+		}
+		agent.streamDoFunc = streamClient.Do
+		agent.streamBackoff = configStreamBackoff
+	}
+
+	agent.configRollbackWindow = defaultConfigRollbackWindow
+	if cfg.ConfigRollbackWindow != "" {
+		window, err := time.ParseDuration(cfg.ConfigRollbackWindow)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config rollback window %s: %w",
+				cfg.ConfigRollbackWindow, err)
+		}
+		agent.configRollbackWindow = window
+	}
+
+	// an agent in offline mode doesn't send tracking events at all
+	if cfg.OtelConfigFile != "" && cfg.OfflineBundle == "" {
+		queueDir := filepath.Join(filepath.Dir(cfg.OtelConfigFile), trackingQueueDirName)
+		agent.trackingQueue = newTrackingQueue(queueDir, maxQueuedEvents)
+	}
+
+	if cfg.ConfigHistorySize > 0 {
+		historyDir := filepath.Join(filepath.Dir(cfg.OtelConfigFile), configHistoryDirName)
+		agent.configHistory = newConfigHistory(historyDir, cfg.ConfigHistorySize)
+	}
 
 	collectorFactories, err := agent.getFactories()
 	if err != nil {
@@ -98,9 +294,10 @@ func NewHostAgent(cfg HostConfig, zapCore zapcore.Core,
 					zap.WrapCore(func(core zapcore.Core) zapcore.Core {
 						return zapCore
 					}),
+					redactingOption(cfg.Secrets()...),
 				}
 			}
-			return []zap.Option{}
+			return []zap.Option{redactingOption(cfg.Secrets()...)}
 		}(),
 
 		BuildInfo: component.BuildInfo{
@@ -147,23 +344,25 @@ const (
 	Clickhouse
 )
 
-type integrationConfiguration struct {
+// IntegrationConfig points to the config of an integration, which is merged
+// into the receivers of the otel config.
+type IntegrationConfig struct {
 	// In future this struct can be extended to further accomodate new integrations.
 	Path     string `json:"path"`
 	Endpoint string `json:"endpoint"`
 }
 
 type apiResponseForYAML struct {
-	Status              bool                     `json:"status"`
-	Config              configType               `json:"config"`
-	PgdbConfig          integrationConfiguration `json:"pgdb_config"`
-	MongodbConfig       integrationConfiguration `json:"mongodb_config"`
-	MysqlConfig         integrationConfiguration `json:"mysql_config"`
-	RedisConfig         integrationConfiguration `json:"redis_config"`
-	ElasticsearchConfig integrationConfiguration `json:"elasticsearch_config"`
-	CassandraConfig     integrationConfiguration `json:"cassandra_config"`
-	ClickhouseConfig    integrationConfiguration `json:"clickhouse_config"`
-	Message             string                   `json:"message"`
+	Status              bool              `json:"status"`
+	Config              configType        `json:"config"`
+	PgdbConfig          IntegrationConfig `json:"pgdb_config"`
+	MongodbConfig       IntegrationConfig `json:"mongodb_config"`
+	MysqlConfig         IntegrationConfig `json:"mysql_config"`
+	RedisConfig         IntegrationConfig `json:"redis_config"`
+	ElasticsearchConfig IntegrationConfig `json:"elasticsearch_config"`
+	CassandraConfig     IntegrationConfig `json:"cassandra_config"`
+	ClickhouseConfig    IntegrationConfig `json:"clickhouse_config"`
+	Message             string            `json:"message"`
 }
 
 type rollout struct {
@@ -180,14 +379,24 @@ type apiResponseForRestart struct {
 
 type TrackingMetadata struct {
 	HostID        string `json:"host_id"`
+	AgentID       string `json:"agent_id,omitempty"`
 	Platform      string `json:"platform"`
 	AgentVersion  string `json:"agent_version"`
 	InfraPlatform string `json:"infra_platform"`
 	Reason        string `json:"reason"`
 }
 type TrackingPayload struct {
-	Status   string           `json:"status"`
-	Metadata TrackingMetadata `json:"metadata"`
+	// ID identifies the event, so that the backend can drop the events
+	// delivered again from the tracking queue
+	ID        string    `json:"id,omitempty"`
+	Status    string    `json:"status"`
+	Timestamp time.Time `json:"timestamp"`
+	// ConfigHash is the hash of the otel config the event is about
+	ConfigHash string `json:"config_hash,omitempty"`
+	// PreviousConfigHash is the hash of the otel config replaced by
+	// ConfigHash
+	PreviousConfigHash string           `json:"previous_config_hash,omitempty"`
+	Metadata           TrackingMetadata `json:"metadata"`
 }
 
 var (
@@ -196,6 +405,17 @@ var (
 	apiAgentTrack     = "api/v1/agent/tracking"
 )
 
+// integrationTypes lists all the supported integrations
+var integrationTypes = []IntegrationType{
+	PostgreSQL,
+	MongoDB,
+	MySQL,
+	Redis,
+	Cassandra,
+	Elasticsearch,
+	Clickhouse,
+}
+
 func (d IntegrationType) String() string {
 	switch d {
 	case PostgreSQL:
@@ -241,51 +461,73 @@ func convertTabsToSpaces(input []byte, tabWidth int) []byte {
 	return output
 }
 
-func (c *HostAgent) updateConfigWithRestrictions(config map[string]interface{}) (map[string]interface{}, error) {
-
-	receiversData, ok := config[Receivers].(map[string]interface{})
-	if !ok {
-		return nil, ErrParseReceivers
+// normalizeYAML converts the map[interface{}]interface{} values produced by
+// yaml.Unmarshal into map[string]interface{} so that the result has the
+// same shape as an otel config decoded from the JSON API response.
+func normalizeYAML(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			m[fmt.Sprint(key)] = normalizeYAML(val)
+		}
+		return m
+	case map[string]interface{}:
+		for key, val := range v {
+			v[key] = normalizeYAML(val)
+		}
+		return v
+	case []interface{}:
+		for i, val := range v {
+			v[i] = normalizeYAML(val)
+		}
+		return v
 	}
+	return value
+}
 
-	serviceData, ok := config[Service].(map[string]interface{})
-	if !ok {
-		return nil, ErrParseService
+// readConfigFile reads the otel config YAML file at path.
+func readConfigFile(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
 	}
 
-	pipelinesData, ok := serviceData[Pipelines].(map[string]interface{})
-	if !ok {
-		return nil, ErrParsePipelines
+	return parseConfig(data)
+}
+
+// parseConfig parses the otel config YAML in data.
+func parseConfig(data []byte) (map[string]interface{}, error) {
+	config := make(map[string]interface{})
+	if err := yaml.Unmarshal(convertTabsToSpaces(data, 2), &config); err != nil {
+		return nil, fmt.Errorf("failed to parse otel config: %w", err)
 	}
 
-	for key, _ := range pipelinesData {
-		if !c.HostConfig.AgentFeatures.LogCollection && strings.HasPrefix(key, "logs") {
-			delete(pipelinesData, key)
-		}
+	return normalizeYAML(config).(map[string]interface{}), nil
+}
 
-		if !c.HostConfig.AgentFeatures.MetricCollection && strings.HasPrefix(key, "metrics") {
-			delete(pipelinesData, key)
-		}
+// updateConfigWithRestrictions removes the components of the features
+// disabled in AgentFeatures from the given config.
+func (c *HostAgent) updateConfigWithRestrictions(config map[string]interface{}) (map[string]interface{}, error) {
+
+	if _, ok := config[Receivers].(map[string]interface{}); !ok {
+		return nil, ErrParseReceivers
 	}
 
-	if !c.HostConfig.AgentFeatures.LogCollection {
-		delete(receiversData, "filelog")
-		delete(receiversData, "windowseventlog")
+	serviceData, ok := config[Service].(map[string]interface{})
+	if !ok {
+		return nil, ErrParseService
 	}
 
-	if !c.HostConfig.AgentFeatures.MetricCollection {
-		delete(receiversData, "hostmetrics")
-		delete(receiversData, "windowsperfcounters")
-		delete(receiversData, "docker_stats")
-		delete(receiversData, "prometheus")
-		delete(receiversData, "kubeletstats")
-		delete(receiversData, "k8s_cluster")
+	if _, ok := serviceData[Pipelines].(map[string]interface{}); !ok {
+		return nil, ErrParsePipelines
 	}
 
+	removeFeatures(config, c.AgentFeatures.disabledToggles())
 	return config, nil
 }
 
-func (c *HostAgent) updateConfig(config map[string]interface{}, cnf integrationConfiguration) (map[string]interface{}, error) {
+func (c *HostAgent) updateConfig(config map[string]interface{}, cnf IntegrationConfig) (map[string]interface{}, error) {
 
 	if c.isIPPortFormat(cnf.Endpoint) {
 		return config, nil
@@ -334,29 +576,98 @@ func (c *HostAgent) updateConfig(config map[string]interface{}, cnf integrationC
 	return config, nil
 }
 
-func (c *HostAgent) updateConfigFile(configType string) error {
-	// _, apiURLForYAML := checkForConfigURLOverrides()
+func (c *HostAgent) updateConfigFile(ctx context.Context, configType string) error {
+	// Ask the backend to skip sending the config if it has not changed since
+	// it was last applied. The ETag is only useful if that config is still
+	// on disk.
+	etag := c.getConfigETag(configType)
+	if !c.hasOtelConfigFile() {
+		etag = ""
+	}
+
+	src, err := c.configSource.FetchConfig(ctx, ConfigRequest{
+		Type: configType,
+		ETag: etag,
+	})
+	if err != nil {
+		return err
+	}
 
-	hostname := getHostname()
+	// reject unsigned and mis-signed configs before they reach the disk
+	if err := c.verifySourceConfig(src); err != nil {
+		c.trackEvent(TrackEventConfigRejected, err, nil, nil)
+		return err
+	}
 
-	// Call Webhook
-	u, err := url.Parse(c.APIURLForConfigCheck)
+	apiYAMLBytes, integrations, err := c.renderConfig(src.Config, src.Integrations)
+	if errors.Is(err, ErrInvalidConfig) {
+		c.trackEvent(TrackEventConfigRejected, err, nil, nil)
+		return err
+	}
 	if err != nil {
 		return err
 	}
 
-	baseURL := u.JoinPath(apiPathForYAML).JoinPath(c.APIKey)
+	// check if the config is valid, otherwise return an error
+	cfg, err := c.loadConfig(apiYAMLBytes)
+	if err != nil {
+		return err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		c.trackEvent(TrackEventConfigRejected, err, apiYAMLBytes, nil)
+		return fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+
+	if c.isRunningConfig(apiYAMLBytes) {
+		c.setConfigETag(configType, src.ETag)
+		c.state.setIntegrations(integrations)
+		return ErrConfigUnchanged
+	}
+
+	// Config sources without ETags keep serving the config which was rolled
+	// back. Applying it again would only fail the collector again.
+	if c.isFailedConfig(apiYAMLBytes) {
+		c.logger.Info("not applying config which failed the collector before",
+			zap.String("config_hash", configHash(apiYAMLBytes)))
+		return ErrConfigUnchanged
+	}
+
+	if err := c.applyConfig(apiYAMLBytes); err != nil {
+		return err
+	}
+
+	c.setConfigETag(configType, src.ETag)
+	c.state.setIntegrations(integrations)
+	return nil
+}
+
+// fetchConfig calls the get configuration api for the given config type. It
+// returns ErrConfigUnchanged if the config has the given ETag. Otherwise, it
+// returns the config of the type along with its ETag and signature.
+func (c *HostAgent) fetchConfig(ctx context.Context, configType string,
+	etag string) (*SourceConfig, error) {
+	// _, apiURLForYAML := checkForConfigURLOverrides()
+
+	// Call Webhook
+	baseURL, err := c.apiURL(apiPathForYAML)
+	if err != nil {
+		return nil, err
+	}
+
 	params := url.Values{}
 	params.Add("config", configType)
 	params.Add("platform", runtime.GOOS)
-	params.Add("host_id", hostname)
+	params.Add("host_id", c.HostID())
+	if c.agentID != "" {
+		params.Add("agent_id", c.agentID)
+	}
 	params.Add("host_tags", c.HostTags)
 	params.Add("agent_version", c.Version)
 	params.Add("infra_platform", fmt.Sprint(c.InfraPlatform))
 
 	collectorRunning := 0
-	// Don't need to take lock on the c.collector because it is not deferenced
-	if c.collector == nil {
+	if c.runningCollector() == nil {
 		collectorRunning = 1
 	}
 	params.Add("col_running", fmt.Sprintf("%d", collectorRunning))
@@ -365,123 +676,287 @@ func (c *HostAgent) updateConfigFile(configType string) error {
 	baseURL.RawQuery = params.Encode() // Escape Query Parameters
 
 	url := baseURL.String()
-	resp, err := c.httpGetFunc(url)
+	resp, err := c.doWithRetry(ctx, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		return req, nil
+	}, c.httpDoFunc)
 	if err != nil {
-		return fmt.Errorf("failed to call get configuration api for %s: %w", url, err)
+		return nil, fmt.Errorf("failed to call get configuration api for %s: %w", c.redactURL(url), err)
 	}
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, ErrConfigUnchanged
+	}
+
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("get configuration api returned non-200 status: %d", resp.StatusCode)
+		return nil, statusError("get configuration api", resp.StatusCode)
 	}
 
 	// Read response body
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read response body: %w", err)
+		return nil, fmt.Errorf("%w: failed to read response body: %v", ErrTransient, err)
 	}
 
 	// Unmarshal JSON response into ApiResponse struct
 	var apiResponse apiResponseForYAML
 	if err := json.Unmarshal(body, &apiResponse); err != nil {
-		return fmt.Errorf("failed to unmarshal api response: %w", err)
+		return nil, fmt.Errorf("%w: failed to unmarshal api response: %v", ErrTransient, err)
 	}
 
-	// Verify API Response
-	if !apiResponse.Status {
-		return fmt.Errorf("failure status from api response for ingestion rules: %t", apiResponse.Status)
+	src, err := apiResponse.sourceConfig(configType, resp.Header.Get("ETag"))
+	if err != nil {
+		return nil, err
 	}
 
-	var apiYAMLConfig map[string]interface{}
-	if len(apiResponse.Config.Docker) == 0 && len(apiResponse.Config.NoDocker) == 0 {
-		return fmt.Errorf("failed to get valid response, config docker len: %d, config no docker len: %d",
-			len(apiResponse.Config.Docker), len(apiResponse.Config.NoDocker))
-	}
+	src.Payload = body
+	src.Signature = resp.Header.Get(configSignatureHeader)
+	return src, nil
+}
 
-	apiYAMLConfig = apiResponse.Config.NoDocker
-	if configType == "docker" {
-		apiYAMLConfig = apiResponse.Config.Docker
+func (c *HostAgent) getConfigETag(configType string) string {
+	c.configMu.Lock()
+	defer c.configMu.Unlock()
+	return c.configETags[configType]
+}
+
+func (c *HostAgent) setConfigETag(configType string, etag string) {
+	c.configMu.Lock()
+	defer c.configMu.Unlock()
+	if c.configETags == nil {
+		c.configETags = map[string]string{}
 	}
+	c.configETags[configType] = etag
+}
+
+// configHash returns the hex encoded sha256 of the given otel config.
+func configHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
 
-	integrationConfigs := map[IntegrationType]integrationConfiguration{
-		PostgreSQL:    apiResponse.PgdbConfig,
-		MongoDB:       apiResponse.MongodbConfig,
-		MySQL:         apiResponse.MysqlConfig,
-		Redis:         apiResponse.RedisConfig,
-		Elasticsearch: apiResponse.ElasticsearchConfig,
-		Cassandra:     apiResponse.CassandraConfig,
-		Clickhouse:    apiResponse.ClickhouseConfig,
+// isRunningConfig checks whether the given otel config is the same as the
+// config in the otel config file, which is what the collector runs with.
+func (c *HostAgent) isRunningConfig(data []byte) bool {
+	runningData, err := os.ReadFile(c.OtelConfigFile)
+	if err != nil {
+		return false
 	}
 
-	for integrationType, integrationConfig := range integrationConfigs {
-		if c.checkIntConfigValidity(integrationType, integrationConfig) {
-			apiYAMLConfig, err = c.updateConfig(apiYAMLConfig, integrationConfig)
+	return configHash(runningData) == configHash(data)
+}
+
+// isFailedConfig checks whether the given otel config was rolled back
+// because the collector failed with it.
+func (c *HostAgent) isFailedConfig(data []byte) bool {
+	c.configMu.Lock()
+	defer c.configMu.Unlock()
+	return c.failedConfigHash != "" && c.failedConfigHash == configHash(data)
+}
+
+// renderConfig merges the integration configs into the given otel config and
+// applies the platform and agent feature specific rewrites to it. It returns
+// the resulting otel config as YAML along with the merged integrations.
+func (c *HostAgent) renderConfig(config map[string]interface{},
+	integrationConfigs map[IntegrationType]IntegrationConfig) ([]byte, []IntegrationType, error) {
+	var err error
+	var integrations []IntegrationType
+	for _, integrationType := range integrationTypes {
+		integrationConfig, ok := integrationConfigs[integrationType]
+		if ok && c.checkIntConfigValidity(integrationType, integrationConfig) {
+			config, err = c.updateConfig(config, integrationConfig)
 			if err != nil {
-				return err
+				return nil, nil, err
 			}
+			integrations = append(integrations, integrationType)
 		}
 	}
 
 	// Add awsecscontainermetrics receiver dynamically if the agent is running inside ECS + Fargate setup
 	if c.InfraPlatform == InfraPlatformECSFargate || c.InfraPlatform == InfraPlatformECSEC2 {
 
-		apiYAMLConfig, err = c.updateConfigForECS(apiYAMLConfig)
+		config, err = c.updateConfigForECS(config)
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
 
 	}
 
-	if !c.AgentFeatures.LogCollection || !c.AgentFeatures.MetricCollection {
-		apiYAMLConfig, err = c.updateConfigWithRestrictions(apiYAMLConfig)
+	c.addAgentIDAttribute(config)
+
+	if len(c.AgentFeatures.disabledToggles()) > 0 {
+		config, err = c.updateConfigWithRestrictions(config)
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
 	}
 
-	apiYAMLBytes, err := yaml.Marshal(apiYAMLConfig)
+	if err := c.applyPolicy(config); err != nil {
+		return nil, nil, err
+	}
+
+	configBytes, err := yaml.Marshal(config)
 	if err != nil {
-		return fmt.Errorf("failed to marshal api data: %w", err)
+		return nil, nil, fmt.Errorf("failed to marshal api data: %w", err)
 	}
 
-	// check if the config is valid, otherwise return an error
+	return configBytes, integrations, nil
+}
+
+// loadConfig resolves the given otel config YAML against the collector
+// factories of the agent.
+func (c *HostAgent) loadConfig(data []byte) (*otelcol.Config, error) {
 	factories, _ := c.getFactories()
-	cfgProviderSettings := c.getConfigProviderSettings("yaml:" + string(apiYAMLBytes))
+	cfgProviderSettings := c.getConfigProviderSettings("yaml:" + string(data))
 	configProvider, err := otelcol.NewConfigProvider(cfgProviderSettings)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	return configProvider.Get(context.Background(), factories)
+}
+
+// applyConfig writes the given otel config to the otel config file so that
+// the collector runs with it on its next start.
+func (c *HostAgent) applyConfig(data []byte) error {
+	// the config file may not exist yet
+	oldData, _ := os.ReadFile(c.OtelConfigFile)
+
+	if err := writeFileAtomicWithBackup(c.OtelConfigFile, data, 0644); err != nil {
+		return fmt.Errorf("failed to write new configuration data to file %s: %w", c.OtelConfigFile, err)
+	}
+
+	c.recordConfigChange(oldData, data)
+	c.recordAppliedConfig(data)
+	c.trackEvent(TrackEventConfigApplied, nil, data, oldData)
+
+	return nil
+}
+
+// recordAppliedConfig adds the newly applied config to the config history
+// and marks it as pending until the collector runs successfully with it.
+func (c *HostAgent) recordAppliedConfig(data []byte) {
+	if c.configHistory == nil {
+		return
 	}
 
-	cfg, err := configProvider.Get(context.Background(), factories)
+	version, err := c.configHistory.record(data)
 	if err != nil {
-		return err
+		c.logger.Warn("failed to record applied config in history", zap.Error(err))
 	}
 
-	if err := cfg.Validate(); err != nil {
-		trackErr := c.UpdateAgentTrackStatus(err)
-		if trackErr != nil {
-			c.logger.Error("failed to update agent track status", zap.Error(trackErr))
+	if version == "" {
+		return
+	}
+
+	c.configMu.Lock()
+	c.pendingConfigVersion = version
+	c.configMu.Unlock()
+}
+
+// promoteAppliedConfig marks the config the collector is running with
+// as the last known good config.
+func (c *HostAgent) promoteAppliedConfig() {
+	if c.configHistory == nil {
+		return
+	}
+
+	c.configMu.Lock()
+	defer c.configMu.Unlock()
+
+	version := c.pendingConfigVersion
+	if version == "" {
+		// If there is no good config in the history yet (e.g. the agent was
+		// upgraded), seed the history with the config on disk.
+		if _, err := c.configHistory.lastGoodVersion(); !errors.Is(err, ErrNoLastGoodConfig) {
+			return
+		}
+
+		data, err := os.ReadFile(c.OtelConfigFile)
+		if err != nil {
+			c.logger.Warn("failed to read otel config file", zap.Error(err))
+			return
+		}
+
+		version, err = c.configHistory.record(data)
+		if version == "" {
+			c.logger.Warn("failed to record otel config in history", zap.Error(err))
+			return
 		}
-		return fmt.Errorf("%w: %v", ErrInvalidConfig, err)
 	}
 
-	if err := os.WriteFile(c.OtelConfigFile, apiYAMLBytes, 0644); err != nil {
-		return fmt.Errorf("failed to write new configuration data to file %s: %w", c.OtelConfigFile, err)
+	if err := c.configHistory.markGood(version); err != nil {
+		c.logger.Warn("failed to mark config as good", zap.Error(err))
+		return
+	}
+
+	c.pendingConfigVersion = ""
+	c.logger.Info("marked config as last known good", zap.String("version", version))
+}
+
+// rollbackConfig restores the last known good config if the collector
+// failed with a config that is yet to prove itself.
+func (c *HostAgent) rollbackConfig() error {
+	if c.configHistory == nil {
+		return ErrNoLastGoodConfig
+	}
+
+	c.configMu.Lock()
+	defer c.configMu.Unlock()
+
+	if c.pendingConfigVersion == "" {
+		return ErrNoLastGoodConfig
 	}
 
+	version, data, err := c.configHistory.lastGood()
+	if err != nil {
+		return err
+	}
+
+	oldData, _ := os.ReadFile(c.OtelConfigFile)
+	if err := writeFileAtomicWithBackup(c.OtelConfigFile, data, 0644); err != nil {
+		return fmt.Errorf("failed to restore config version %s to file %s: %w",
+			version, c.OtelConfigFile, err)
+	}
+	c.recordConfigChange(oldData, data)
+	c.failedConfigHash = configHash(oldData)
+
+	c.logger.Warn("rolled back to last known good config",
+		zap.String("failed_version", c.pendingConfigVersion),
+		zap.String("restored_version", version))
+	c.pendingConfigVersion = ""
+
 	return nil
 }
 
-// GetUpdatedYAMLPath gets the correct otel configuration file
-func (c *HostAgent) getOtelConfig() (string, error) {
-	configType := "docker"
+// getConfigType returns the type of otel config required by the agent
+// depending on whether docker is running on the host.
+func (c *HostAgent) getConfigType() string {
 	dockerSocketPath := strings.Split(c.DockerEndpoint, "//")
 	if len(dockerSocketPath) != 2 || !isSocketFn(dockerSocketPath[1]) {
-		configType = "nodocker"
+		return "nodocker"
 	}
+	return "docker"
+}
 
-	if err := c.updateConfigFile(configType); err != nil {
+// GetUpdatedYAMLPath gets the correct otel configuration file
+func (c *HostAgent) getOtelConfig(ctx context.Context) (string, error) {
+	err := c.updateConfigFile(ctx, c.getConfigType())
+	if errors.Is(err, ErrConfigUnchanged) {
+		c.state.recordConfigFetch(nil)
+	} else {
+		c.state.recordConfigFetch(err)
+	}
+
+	if err != nil {
 		return c.OtelConfigFile, err
 	}
 
@@ -510,7 +985,7 @@ func (c *HostAgent) isIPPortFormat(endpoint string) bool {
 	return false
 }
 
-func (c *HostAgent) checkIntConfigValidity(integrationType IntegrationType, cnf integrationConfiguration) bool {
+func (c *HostAgent) checkIntConfigValidity(integrationType IntegrationType, cnf IntegrationConfig) bool {
 	if cnf.Path != "" {
 		// Check if the file exists
 		if _, err := os.Stat(cnf.Path); os.IsNotExist(err) {
@@ -527,26 +1002,25 @@ func (c *HostAgent) checkIntConfigValidity(integrationType IntegrationType, cnf
 	return false
 }
 
-func (c *HostAgent) callRestartStatusAPI() error {
+func (c *HostAgent) callRestartStatusAPI(ctx context.Context) error {
 
 	// apiURLForRestart, _ := checkForConfigURLOverrides()
-	hostname := getHostname()
-	u, err := url.Parse(c.APIURLForConfigCheck)
+	baseURL, err := c.apiURL(apiPathForRestart)
 	if err != nil {
 		return err
 	}
 
-	baseURL := u.JoinPath(apiPathForRestart)
-	baseURL = baseURL.JoinPath(c.APIKey)
 	params := url.Values{}
-	params.Add("host_id", hostname)
+	params.Add("host_id", c.HostID())
+	if c.agentID != "" {
+		params.Add("agent_id", c.agentID)
+	}
 	params.Add("platform", runtime.GOOS)
 	params.Add("agent_version", c.Version)
 	params.Add("infra_platform", fmt.Sprint(c.InfraPlatform))
 
 	collectorRunning := 0
-	// Don't need to take lock on the c.collector because it is not deferenced
-	if c.collector == nil {
+	if c.runningCollector() == nil {
 		collectorRunning = 1
 	}
 	params.Add("col_running", fmt.Sprintf("%d", collectorRunning))
@@ -554,119 +1028,190 @@ func (c *HostAgent) callRestartStatusAPI() error {
 	// Add Query Parameters to the URL
 	baseURL.RawQuery = params.Encode() // Escape Query Parameters
 
-	client := &http.Client{Timeout: 10 * time.Second}
 	url := baseURL.String()
-	resp, err := client.Get(url)
+	resp, err := c.doWithRetry(ctx, func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	}, c.httpDoFunc)
 	if err != nil {
-		return fmt.Errorf("failed to call restart api for url %s: %w", url, err)
+		return fmt.Errorf("failed to call restart api for url %s: %w", c.redactURL(url), err)
 	}
 
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("restart api returned non-200 status: %d", resp.StatusCode)
+		return statusError("restart api", resp.StatusCode)
 	}
 
 	var apiResponse apiResponseForRestart
 	if err := json.NewDecoder(resp.Body).Decode(&apiResponse); err != nil {
-		return fmt.Errorf("failed to unmarshal restart api response: %w", err)
+		return fmt.Errorf("%w: failed to unmarshal restart api response: %v", ErrTransient, err)
 	}
 
 	if apiResponse.Restart {
-		c.logger.Info("fetching updated configuration from backend")
-		if _, err := c.getOtelConfig(); err != nil {
-			return err
-		}
-
-		return ErrRestartAgent
+		runningData, _ := os.ReadFile(c.OtelConfigFile)
+		c.trackEvent(TrackEventRestartRequested, nil, runningData, nil)
+		return c.refreshConfig(ctx)
 	}
 
 	return err
 }
 
+// refreshConfig fetches the otel config from the config source and returns
+// ErrRestartAgent if it changed.
+func (c *HostAgent) refreshConfig(ctx context.Context) error {
+	c.logger.Info("fetching updated configuration", zap.Stringer("config-source", c.configSource))
+	if _, err := c.getOtelConfig(ctx); err != nil {
+		if errors.Is(err, ErrConfigUnchanged) {
+			c.logger.Info("configuration is unchanged, not restarting collector")
+			return nil
+		}
+		return err
+	}
+
+	return ErrRestartAgent
+}
+
+// hasAPIConfigSource checks whether the otel config is fetched from
+// Middleware backend.
+func (c *HostAgent) hasAPIConfigSource() bool {
+	_, ok := c.configSource.(*hostAPIConfigSource)
+	return ok
+}
+
+// checkConfigChanges checks for a change of the otel config. Middleware
+// backend is asked through the restart status api whether the config
+// changed, other config sources are fetched every time.
+func (c *HostAgent) checkConfigChanges(ctx context.Context) error {
+	if c.hasAPIConfigSource() {
+		return c.callRestartStatusAPI(ctx)
+	}
+	return c.refreshConfig(ctx)
+}
+
 // ListenForConfigChanges listens for configuration changes for the
 // agent on the Middleware backend and restarts the agent if configuration
-// has changed.
+// has changed. The result of every check is sent to errCh: nil if the
+// collector should run, or one of ErrRestartAgent, ErrInvalidConfig,
+// ErrTransient and ErrAuthRevoked. If there is an otel config file already,
+// the collector is started with it and the first fetch waits for a random
+// splay within the config check interval.
 func (c *HostAgent) ListenForConfigChanges(errCh chan<- error,
 	stopCh <-chan struct{}) error {
 
-	// First fetch the config
-	_, err := c.getOtelConfig()
-	if err != nil {
-		errCh <- err
-	} else {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	restartInterval, intervalErr := time.ParseDuration(c.ConfigCheckInterval)
+
+	// Don't poll in lockstep with the other agents started at the same time
+	firstPoll := splay(restartInterval)
+
+	if intervalErr == nil && restartInterval > 0 && c.hasOtelConfigFile() {
+		// Start collecting with the existing config and fetch the config
+		// after the splay, so that a fleet of agents restarted together
+		// doesn't hit the backend all at once.
 		errCh <- nil
+
+		timer := time.NewTimer(firstPoll)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+
+		errCh <- c.refreshConfig(ctx)
+		firstPoll = restartInterval
+	} else {
+		// There is no config to start with, so fetch it right away
+		_, err := c.getOtelConfig(ctx)
+		switch {
+		case err == nil || errors.Is(err, ErrConfigUnchanged):
+			errCh <- nil
+		case errors.Is(err, ErrTransient) && c.hasOtelConfigFile():
+			c.logger.Warn("backend unavailable, starting collector with the existing config",
+				zap.Error(err))
+			errCh <- nil
+		default:
+			errCh <- err
+		}
 	}
 
-	restartInterval, err := time.ParseDuration(c.ConfigCheckInterval)
-	if err != nil {
-		return err
+	if intervalErr != nil {
+		return intervalErr
 	}
 
-	ticker := time.NewTicker(restartInterval)
+	// notify is signalled when the backend pushes a config change over the
+	// config stream. Polling keeps running as the fallback for the stream.
+	var notify chan struct{}
+	if c.streamDoFunc != nil {
+		notify = make(chan struct{}, 1)
+		go c.runConfigStream(ctx, notify)
+	}
+
+	if restartInterval <= 0 && notify == nil {
+		return nil
+	}
+
+	// pollCh stays nil, so that it never fires, if polling is disabled
+	var pollCh <-chan time.Time
+	var pollTimer *time.Timer
+	if restartInterval > 0 {
+		pollTimer = time.NewTimer(firstPoll)
+		defer pollTimer.Stop()
+		pollCh = pollTimer.C
+	}
 
 	for {
 		c.logger.Debug("checking for config change every",
 			zap.String("restartInterval", restartInterval.String()))
 		select {
-		case <-stopCh:
-			ticker.Stop()
+		case <-ctx.Done():
 			return nil
-		case <-ticker.C:
-			err = c.callRestartStatusAPI()
-			errCh <- err
+		case <-pollCh:
+			errCh <- c.checkConfigChanges(ctx)
+			c.retryTrackingEvents()
+			pollTimer.Reset(restartInterval)
+		case <-notify:
+			errCh <- c.checkConfigChanges(ctx)
 		}
 	}
 }
 
-func (c *HostAgent) UpdateAgentTrackStatus(reason error) error {
-	c.logger.Info("Starting UpdateAgentTrackStatus")
-	hostname := getHostname()
-	u, err := url.Parse(c.APIURLForConfigCheck)
-	if err != nil {
-		return err
-	}
-	baseURL := u.JoinPath(apiAgentTrack)
-	baseURL = baseURL.JoinPath(c.APIKey)
-	payload := TrackingPayload{
-		Status: "validate",
-		Metadata: TrackingMetadata{
-			HostID:        hostname,
-			Platform:      runtime.GOOS,
-			AgentVersion:  c.Version,
-			InfraPlatform: fmt.Sprint(c.InfraPlatform),
-			Reason:        reason.Error(),
-		},
-	}
-	// Marshal payload to JSON
-	payloadBytes, err := json.Marshal(payload)
-	if err != nil {
-		return fmt.Errorf("failed to marshal payload: %w", err)
-	}
-	req, err := http.NewRequest(http.MethodPost, baseURL.String(), bytes.NewBuffer(payloadBytes))
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
-	// Add headers
-	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{Timeout: 10 * time.Second}
-	// Make the request
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("Agent Track API request failed: %w", err)
+// HostID returns the ID of the host sent to the backend, the ID of the
+// cloud instance if known, or the hostname.
+func (c *HostAgent) HostID() string {
+	if c.cloudInstance != nil {
+		return c.cloudInstance.HostID()
 	}
-	defer resp.Body.Close()
-	// Check status code
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("Agent Track API returned non-200 status code: %d", resp.StatusCode)
-	}
-	c.logger.Info("Successfully updated agent track status")
-	return nil
+	return getHostname()
+}
+
+// hasOtelConfigFile checks whether there is an otel config file which the
+// collector can run with.
+func (c *HostAgent) hasOtelConfigFile() bool {
+	_, err := os.Stat(c.OtelConfigFile)
+	return err == nil
 }
 
 // StartCollector initializes a new OpenTelemetry collector with the configured
 // settings and starts it. This function blocks until the collector is stopped
 func (c *HostAgent) StartCollector() error {
+	c.collectorMu.Lock()
+	defer c.collectorMu.Unlock()
+	return c.startCollector()
+}
+
+// startCollector starts a collector unless one is running. The caller must
+// hold collectorMu.
+func (c *HostAgent) startCollector() error {
 	if c.collector != nil {
 		return nil
 	}
@@ -681,26 +1226,105 @@ func (c *HostAgent) StartCollector() error {
 	c.collectorWG.Add(1)
 	go func() {
 		defer c.collectorWG.Done()
-		if err := collector.Run(context.Background()); err != nil {
-			c.logger.Error("collector server run finished with error",
-				zap.Error(err))
-			c.collector = nil
-		} else {
+
+		c.startStableTimer()
+		err := collector.Run(context.Background())
+		sinceConfigLoaded := c.stopStableTimer()
+		if err == nil {
 			c.logger.Info("collector server run finished gracefully")
+			return
+		}
+
+		c.logger.Error("collector server run finished with error",
+			zap.Error(err))
+
+		c.collectorMu.Lock()
+		defer c.collectorMu.Unlock()
+
+		// StopCollector or another start has taken over the collector
+		if c.collector != collector {
+			return
+		}
+		c.collector = nil
+
+		failedData, _ := os.ReadFile(c.OtelConfigFile)
+		c.trackEvent(TrackEventCollectorStopped, err, failedData, nil)
+
+		if sinceConfigLoaded >= c.configRollbackWindow {
+			return
 		}
 
+		// The collector failed soon after loading its config. If it was running a
+		// newly applied config, go back to the last known good config.
+		if rollbackErr := c.rollbackConfig(); rollbackErr != nil {
+			c.logger.Info("not rolling back config", zap.Error(rollbackErr))
+			return
+		}
+
+		restoredData, _ := os.ReadFile(c.OtelConfigFile)
+		c.trackEvent(TrackEventCrashRestarted, err, restoredData, failedData)
+
+		if startErr := c.startCollector(); startErr != nil {
+			c.logger.Error("failed to start collector with last known good config",
+				zap.Error(startErr))
+		}
 	}()
 
+	configData, _ := os.ReadFile(c.OtelConfigFile)
+	c.trackEvent(TrackEventCollectorStarted, nil, configData, nil)
 	return nil
 
 }
 
+// runningCollector returns the collector, nil if it isn't running.
+func (c *HostAgent) runningCollector() *otelcol.Collector {
+	c.collectorMu.Lock()
+	defer c.collectorMu.Unlock()
+	return c.collector
+}
+
+// startStableTimer is called whenever the collector loads its config. If
+// the collector keeps running for the rollback window, the config it is
+// running with is considered good.
+func (c *HostAgent) startStableTimer() {
+	c.configMu.Lock()
+	defer c.configMu.Unlock()
+
+	if c.stableTimer != nil {
+		c.stableTimer.Stop()
+	}
+	c.configLoadedAt = time.Now()
+	c.stableTimer = time.AfterFunc(c.configRollbackWindow, c.promoteAppliedConfig)
+}
+
+// stopStableTimer is called when the collector stops. It returns the
+// duration for which the collector ran with its last loaded config.
+func (c *HostAgent) stopStableTimer() time.Duration {
+	c.configMu.Lock()
+	defer c.configMu.Unlock()
+
+	if c.stableTimer != nil {
+		c.stableTimer.Stop()
+		c.stableTimer = nil
+	}
+	return time.Since(c.configLoadedAt)
+}
+
 func (c *HostAgent) StopCollector(err error) {
-	if c.collector != nil {
+	// the collector is taken under the lock, but waited for without it as
+	// its goroutine takes the lock when the collector fails
+	c.collectorMu.Lock()
+	collector := c.collector
+	c.collector = nil
+	c.collectorMu.Unlock()
+
+	if collector != nil {
 		c.logger.Info("stopping telemetry collection", zap.Error(err))
-		c.collector.Shutdown()
+		collector.Shutdown()
 		c.collectorWG.Wait()
 		c.logger.Info("stopped telemetry collection at", zap.Time("time", time.Now()))
-		c.collector = nil
+
+		configData, _ := os.ReadFile(c.OtelConfigFile)
+		c.trackEvent(TrackEventCollectorStopped, err, configData, nil)
 	}
 }
@@ -1,6 +1,8 @@
 package agent
 
 import (
+	"os"
+
 	"github.com/open-telemetry/opentelemetry-collector-contrib/exporter/fileexporter"
 	"github.com/open-telemetry/opentelemetry-collector-contrib/exporter/kafkaexporter"
 	"github.com/open-telemetry/opentelemetry-collector-contrib/extension/healthcheckextension"
@@ -127,3 +129,20 @@ func (c *HostAgent) getFactories() (otelcol.Factories, error) {
 
 	return factories, nil
 }
+
+// replaceFile atomically renames src over dst.
+func replaceFile(src, dst string) error {
+	return os.Rename(src, dst)
+}
+
+// syncDir flushes the directory entry of a renamed file to disk so that
+// the rename survives a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
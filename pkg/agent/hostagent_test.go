@@ -1,10 +1,16 @@
 package agent
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -31,7 +37,7 @@ func TestUpdatepgdbConfig(t *testing.T) {
 		},
 	}
 
-	pgdbConfig := integrationConfiguration{
+	pgdbConfig := IntegrationConfig{
 		Path: "db-config_test.yaml",
 	}
 
@@ -67,7 +73,7 @@ func TestUpdateMongodbConfig(t *testing.T) {
 		},
 	}
 
-	mongodbConfig := integrationConfiguration{
+	mongodbConfig := IntegrationConfig{
 		Path: "db-config_test.yaml",
 	}
 
@@ -103,7 +109,7 @@ func TestUpdateMysqlConfig(t *testing.T) {
 		},
 	}
 
-	mysqlConfig := integrationConfiguration{
+	mysqlConfig := IntegrationConfig{
 		Path: "db-config_test.yaml",
 	}
 
@@ -138,7 +144,7 @@ func TestUpdateRedisConfig(t *testing.T) {
 		},
 	}
 
-	redisConfig := integrationConfiguration{
+	redisConfig := IntegrationConfig{
 		Path: "db-config_test.yaml",
 	}
 
@@ -171,10 +177,11 @@ func TestListenForConfigChanges(t *testing.T) {
 
 	zapCore := zapcore.NewNopCore()
 	agent, _ := NewHostAgent(cfg, zapCore)
-	agent.httpGetFunc = func(url string) (resp *http.Response, err error) {
-		return nil, fmt.Errorf("failed to call get configuration api for %s: %w", url,
+	agent.httpDoFunc = func(req *http.Request) (resp *http.Response, err error) {
+		return nil, fmt.Errorf("failed to call get configuration api for %s: %w", req.URL,
 			errors.New("test error"))
 	}
+	agent.backoff = backoff{initial: time.Millisecond, max: time.Millisecond, maxAttempts: 2}
 
 	errCh := make(chan error)
 
@@ -201,6 +208,46 @@ func TestListenForConfigChanges(t *testing.T) {
 	assert.True(t, true)
 }
 
+func TestListenForConfigChangesSplay(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	otelConfigFile := filepath.Join(t.TempDir(), "otel-config.yaml")
+	assert.NoError(t, os.WriteFile(otelConfigFile, []byte(sourceTestConfig), 0644))
+
+	agent, err := NewHostAgent(HostConfig{
+		BaseConfig: BaseConfig{
+			APIKey:               "testAPIKey",
+			APIURLForConfigCheck: server.URL,
+			ConfigCheckInterval:  "1h",
+			OtelConfigFile:       otelConfigFile,
+		},
+	}, zapcore.NewNopCore())
+	assert.NoError(t, err)
+	closeAgent(t, agent)
+
+	errCh := make(chan error)
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		assert.NoError(t, agent.ListenForConfigChanges(errCh, stopCh))
+		close(done)
+	}()
+
+	// the collector starts with the existing config, while the first fetch
+	// waits for the splay
+	assert.NoError(t, <-errCh)
+	time.Sleep(100 * time.Millisecond)
+	assert.Zero(t, requests.Load())
+
+	close(stopCh)
+	<-done
+}
+
 func assertContainsComponent(t *testing.T, factoryMap interface{}, componentName string) {
 	t.Helper()
 
@@ -408,8 +455,9 @@ func TestUpdateAgentTrackStatus(t *testing.T) {
 						APIURLForConfigCheck: mockServer.URL,
 					},
 				},
-				logger:  logger,
-				Version: "1.0.0",
+				logger:     logger,
+				httpDoFunc: http.DefaultClient.Do,
+				Version:    "1.0.0",
 			}
 
 			err := hostAgent.UpdateAgentTrackStatus(errors.New("test reason"))
@@ -424,3 +472,134 @@ func TestUpdateAgentTrackStatus(t *testing.T) {
 		})
 	}
 }
+
+func TestUpdateConfigFileUnchanged(t *testing.T) {
+	config := map[string]interface{}{
+		"receivers": map[string]interface{}{
+			"otlp": map[string]interface{}{
+				"protocols": map[string]interface{}{
+					"grpc": map[string]interface{}{
+						"endpoint": "127.0.0.1:0",
+					},
+				},
+			},
+		},
+		"exporters": map[string]interface{}{
+			"debug": map[string]interface{}{},
+		},
+		"service": map[string]interface{}{
+			"pipelines": map[string]interface{}{
+				"metrics": map[string]interface{}{
+					"receivers": []string{"otlp"},
+					"exporters": []string{"debug"},
+				},
+			},
+		},
+	}
+
+	const etag = `"v1"`
+	supportsETag := true
+	requests := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// applying the config is tracked
+		if strings.Contains(r.URL.Path, apiAgentTrack) {
+			return
+		}
+
+		requests++
+		if supportsETag && r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		if supportsETag {
+			w.Header().Set("ETag", etag)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": true,
+			"config": map[string]interface{}{
+				"nodocker": config,
+			},
+		})
+	}))
+	defer mockServer.Close()
+
+	otelConfigFile := filepath.Join(t.TempDir(), "otel-config.yaml")
+	agent, err := NewHostAgent(HostConfig{
+		BaseConfig: BaseConfig{
+			APIKey:               "testAPIKey",
+			APIURLForConfigCheck: mockServer.URL,
+			OtelConfigFile:       otelConfigFile,
+			AgentFeatures: AgentFeatures{
+				MetricCollection: true,
+				LogCollection:    true,
+			},
+		},
+	}, zapcore.NewNopCore())
+	assert.NoError(t, err)
+	closeAgent(t, agent)
+
+	// first fetch writes the config
+	assert.NoError(t, agent.updateConfigFile(context.Background(), "nodocker"))
+	_, err = os.Stat(otelConfigFile)
+	assert.NoError(t, err)
+
+	// second fetch is answered with 304 Not Modified
+	assert.ErrorIs(t, agent.updateConfigFile(context.Background(), "nodocker"), ErrConfigUnchanged)
+	assert.Equal(t, 2, requests)
+
+	// without ETag support, the rendered config is compared with the
+	// running config
+	supportsETag = false
+	agent.setConfigETag("nodocker", "")
+	assert.ErrorIs(t, agent.updateConfigFile(context.Background(), "nodocker"), ErrConfigUnchanged)
+	assert.Equal(t, 3, requests)
+}
+
+// closeAgent closes agent once the test is done, before the temp dirs of
+// the test are removed, so that no tracking event is queued in them later.
+func closeAgent(t *testing.T, agent *HostAgent) {
+	t.Cleanup(func() {
+		assert.NoError(t, agent.Close(context.Background()))
+	})
+}
+
+func TestStatusError(t *testing.T) {
+	tests := []struct {
+		statusCode int
+		wantErr    error
+	}{
+		{statusCode: http.StatusUnauthorized, wantErr: ErrAuthRevoked},
+		{statusCode: http.StatusForbidden, wantErr: ErrAuthRevoked},
+		{statusCode: http.StatusBadGateway, wantErr: ErrTransient},
+		{statusCode: http.StatusTooManyRequests, wantErr: ErrTransient},
+		{statusCode: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		err := statusError("restart api", tt.statusCode)
+		assert.Error(t, err)
+		if tt.wantErr != nil {
+			assert.ErrorIs(t, err, tt.wantErr)
+			continue
+		}
+		assert.False(t, errors.Is(err, ErrAuthRevoked) || errors.Is(err, ErrTransient))
+	}
+}
+
+func TestCallRestartStatusAPIAuthRevoked(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer mockServer.Close()
+
+	agent, err := NewHostAgent(HostConfig{
+		BaseConfig: BaseConfig{
+			APIKey:               "testAPIKey",
+			APIURLForConfigCheck: mockServer.URL,
+		},
+	}, zapcore.NewNopCore())
+	assert.NoError(t, err)
+
+	assert.ErrorIs(t, agent.callRestartStatusAPI(context.Background()), ErrAuthRevoked)
+}
@@ -1,6 +1,9 @@
 package agent
 
 import (
+	"errors"
+	"time"
+
 	"github.com/open-telemetry/opentelemetry-collector-contrib/exporter/kafkaexporter"
 	"github.com/open-telemetry/opentelemetry-collector-contrib/extension/healthcheckextension"
 	"github.com/open-telemetry/opentelemetry-collector-contrib/processor/attributesprocessor"
@@ -44,6 +47,7 @@ import (
 	"go.opentelemetry.io/collector/processor/memorylimiterprocessor"
 	"go.opentelemetry.io/collector/receiver"
 	"go.opentelemetry.io/collector/receiver/otlpreceiver"
+	"golang.org/x/sys/windows"
 )
 
 // GetFactories get otel factories for HostAgent
@@ -118,3 +122,47 @@ func (c *HostAgent) getFactories() (otelcol.Factories, error) {
 
 	return factories, nil
 }
+
+const (
+	replaceFileAttempts   = 5
+	replaceFileRetryDelay = 100 * time.Millisecond
+)
+
+// replaceFile atomically moves src over dst. Unlike on Unix, the move fails
+// on Windows while another process (e.g. an antivirus scanner) holds dst
+// open without FILE_SHARE_DELETE, so the move is retried a few times.
+// MOVEFILE_WRITE_THROUGH makes the move return only after it is flushed
+// to disk.
+func replaceFile(src, dst string) error {
+	srcPtr, err := windows.UTF16PtrFromString(src)
+	if err != nil {
+		return err
+	}
+
+	dstPtr, err := windows.UTF16PtrFromString(dst)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = windows.MoveFileEx(srcPtr, dstPtr,
+			windows.MOVEFILE_REPLACE_EXISTING|windows.MOVEFILE_WRITE_THROUGH)
+		if err == nil {
+			return nil
+		}
+
+		if attempt == replaceFileAttempts ||
+			!(errors.Is(err, windows.ERROR_SHARING_VIOLATION) ||
+				errors.Is(err, windows.ERROR_ACCESS_DENIED)) {
+			return err
+		}
+
+		time.Sleep(replaceFileRetryDelay)
+	}
+}
+
+// syncDir is a no-op on Windows since directories can't be opened for
+// syncing and replaceFile already writes through.
+func syncDir(_ string) error {
+	return nil
+}
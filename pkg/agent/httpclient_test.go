@@ -0,0 +1,89 @@
+package agent
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewHTTPClient(t *testing.T) {
+	client, err := NewHTTPClient(HTTPClientConfig{})
+	assert.NoError(t, err)
+	assert.Equal(t, defaultHTTPClientTimeout, client.Timeout)
+
+	client, err = NewHTTPClient(HTTPClientConfig{
+		Timeout:       "3s",
+		TLSMinVersion: "1.3",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3*time.Second, client.Timeout)
+	transport := client.Transport.(*http.Transport)
+	assert.Equal(t, uint16(tls.VersionTLS13), transport.TLSClientConfig.MinVersion)
+
+	_, err = NewHTTPClient(HTTPClientConfig{TLSMinVersion: "2.0"})
+	assert.ErrorIs(t, err, ErrInvalidTLSVersion)
+
+	_, err = NewHTTPClient(HTTPClientConfig{Timeout: "soon"})
+	assert.Error(t, err)
+
+	_, err = NewHTTPClient(HTTPClientConfig{ClientCertFile: "client.pem"})
+	assert.ErrorIs(t, err, ErrIncompleteCertPair)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	assert.NoError(t, os.WriteFile(caFile, []byte("not a certificate"), 0600))
+	_, err = NewHTTPClient(HTTPClientConfig{CAFile: caFile})
+	assert.ErrorIs(t, err, ErrNoCACertificates)
+}
+
+func TestNewHTTPClientProxy(t *testing.T) {
+	client, err := NewHTTPClient(HTTPClientConfig{
+		ProxyURL: "http://proxy.example.com:3128",
+		NoProxy:  "internal.example.com",
+	})
+	assert.NoError(t, err)
+	transport := client.Transport.(*http.Transport)
+
+	req := httptest.NewRequest(http.MethodGet, "https://app.middleware.io/api", nil)
+	proxyURL, err := transport.Proxy(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "proxy.example.com:3128", proxyURL.Host)
+
+	req = httptest.NewRequest(http.MethodGet, "https://internal.example.com/api", nil)
+	proxyURL, err = transport.Proxy(req)
+	assert.NoError(t, err)
+	assert.Nil(t, proxyURL)
+}
+
+func TestNewHTTPClientCAFile(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	// without the CA of the test server, the call fails
+	client, err := NewHTTPClient(HTTPClientConfig{})
+	assert.NoError(t, err)
+	_, err = client.Get(server.URL)
+	assert.Error(t, err)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	assert.NoError(t, os.WriteFile(caFile, pemEncodeCert(server.Certificate().Raw), 0600))
+
+	client, err = NewHTTPClient(HTTPClientConfig{CAFile: caFile})
+	assert.NoError(t, err)
+	resp, err := client.Get(server.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func pemEncodeCert(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
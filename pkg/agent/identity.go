@@ -0,0 +1,90 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const (
+	agentIDFileName = "agent-id"
+	// agentIDAttribute is the resource attribute which carries the agent ID
+	agentIDAttribute = "mw.host.id"
+	agentIDProcessor = "resource/mw_host_id"
+)
+
+var ErrInvalidAgentID = errors.New("invalid agent id")
+
+// AgentIDFile returns the file of the agent ID in the given state
+// directory.
+func AgentIDFile(stateDir string) string {
+	return filepath.Join(stateDir, agentIDFileName)
+}
+
+// ReadAgentID returns the agent ID persisted in the given state directory.
+// It returns an error wrapping os.ErrNotExist if there is none yet.
+func ReadAgentID(stateDir string) (string, error) {
+	path := AgentIDFile(stateDir)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	id := strings.TrimSpace(string(data))
+	if _, err := uuid.Parse(id); err != nil {
+		return "", fmt.Errorf("%w in %s: %v", ErrInvalidAgentID, path, err)
+	}
+
+	return id, nil
+}
+
+// LoadOrCreateAgentID returns the agent ID persisted in the given state
+// directory. On the first start, a random UUID is generated and persisted,
+// so that the host keeps its identity across renames and rebuilds. created
+// reports whether the ID was generated.
+func LoadOrCreateAgentID(stateDir string) (id string, created bool, err error) {
+	id, err = ReadAgentID(stateDir)
+	if !errors.Is(err, os.ErrNotExist) {
+		return id, false, err
+	}
+
+	id = uuid.NewString()
+	if err := writeFileAtomic(AgentIDFile(stateDir), []byte(id+"\n"), 0644); err != nil {
+		return "", false, err
+	}
+
+	return id, true, nil
+}
+
+// ResetAgentID removes the agent ID persisted in the given state directory,
+// e.g. before the host is captured as a golden image. A new ID is generated
+// on the next start.
+func ResetAgentID(stateDir string) error {
+	err := os.Remove(AgentIDFile(stateDir))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// addAgentIDAttribute adds the agent ID as a resource attribute to every
+// pipeline of config.
+func (c *HostAgent) addAgentIDAttribute(config map[string]interface{}) {
+	if c.agentID == "" {
+		return
+	}
+
+	prependProcessor(config, agentIDProcessor, map[string]interface{}{
+		"attributes": []interface{}{
+			map[string]interface{}{
+				"key":    agentIDAttribute,
+				"value":  c.agentID,
+				"action": "upsert",
+			},
+		},
+	})
+}
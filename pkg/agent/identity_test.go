@@ -0,0 +1,102 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestLoadOrCreateAgentID(t *testing.T) {
+	stateDir := t.TempDir()
+
+	_, err := ReadAgentID(stateDir)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	id, created, err := LoadOrCreateAgentID(stateDir)
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.NotEmpty(t, id)
+
+	// the id is stable across starts
+	again, created, err := LoadOrCreateAgentID(stateDir)
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, id, again)
+
+	read, err := ReadAgentID(stateDir)
+	require.NoError(t, err)
+	assert.Equal(t, id, read)
+
+	// a new id is generated after a reset
+	require.NoError(t, ResetAgentID(stateDir))
+	require.NoError(t, ResetAgentID(stateDir))
+	reset, created, err := LoadOrCreateAgentID(stateDir)
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.NotEqual(t, id, reset)
+
+	require.NoError(t, os.WriteFile(AgentIDFile(stateDir), []byte("not-a-uuid\n"), 0644))
+	_, _, err = LoadOrCreateAgentID(stateDir)
+	assert.ErrorIs(t, err, ErrInvalidAgentID)
+}
+
+func TestHostAgentID(t *testing.T) {
+	var agentIDs []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agentIDs = append(agentIDs, r.URL.Query().Get("agent_id"))
+		_, _ = w.Write([]byte(`{"status": true, "restart": false}`))
+	}))
+	defer server.Close()
+
+	cfg := HostConfig{
+		BaseConfig: BaseConfig{
+			APIKey:               "testAPIKey",
+			APIURLForConfigCheck: server.URL,
+		},
+	}
+
+	id, _, err := LoadOrCreateAgentID(t.TempDir())
+	require.NoError(t, err)
+
+	agent, err := NewHostAgent(cfg, zapcore.NewNopCore(), WithHostAgentID(id))
+	require.NoError(t, err)
+	assert.NoError(t, agent.callRestartStatusAPI(context.Background()))
+	assert.Equal(t, []string{id}, agentIDs)
+}
+
+func TestRenderConfigAgentID(t *testing.T) {
+	responseFile := filepath.Join(t.TempDir(), "response.json")
+	require.NoError(t, os.WriteFile(responseFile, []byte(renderAPIResponse), 0644))
+
+	agent := newRenderAgent(t, HostConfig{})
+	agent.agentID = "0b3c4d5e-6f70-4812-9a3b-4c5d6e7f8091"
+
+	data, err := agent.RenderConfig(context.Background(), responseFile, "nodocker")
+	require.NoError(t, err)
+	config, err := parseConfig(data)
+	require.NoError(t, err)
+
+	processor := configSection(config, "processors", agentIDProcessor)
+	require.NotNil(t, processor)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{
+			"key":    agentIDAttribute,
+			"value":  agent.agentID,
+			"action": "upsert",
+		},
+	}, processor["attributes"])
+
+	pipelines := configSection(config, Service, Pipelines)
+	require.NotEmpty(t, pipelines)
+	for name, pipeline := range pipelines {
+		processors := pipeline.(map[string]interface{})["processors"].([]interface{})
+		assert.Equal(t, agentIDProcessor, processors[0], name)
+	}
+}
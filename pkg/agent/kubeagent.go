@@ -1,13 +1,16 @@
 package agent
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"time"
 
+	"github.com/google/uuid"
 	yaml "gopkg.in/yaml.v2"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
@@ -71,6 +74,10 @@ type KubeAgentMonitor struct {
 	ClusterName string
 	logger      *zap.Logger
 	Version     string
+	httpClient  *http.Client
+
+	// configSource provides the otel configs, Middleware backend by default
+	configSource ConfigSource
 }
 
 type ComponentType int
@@ -114,6 +121,7 @@ func NewKubeAgent(cfg KubeConfig, opts ...KubeOptions) *KubeAgent {
 	if agent.logger == nil {
 		agent.logger, _ = zap.NewProduction()
 	}
+	agent.logger = agent.logger.WithOptions(redactingOption(cfg.Secrets()...))
 
 	return &agent
 }
@@ -129,10 +137,27 @@ func NewKubeAgentMonitor(cfg KubeConfig, opts ...KubeAgentMonitorOptions) *KubeA
 	if agent.logger == nil {
 		agent.logger, _ = zap.NewProduction()
 	}
+	agent.logger = agent.logger.WithOptions(redactingOption(cfg.Secrets()...))
 
 	return &agent
 }
 
+// getHTTPClient returns the HTTP client for the calls to the Middleware backend
+func (c *KubeAgentMonitor) getHTTPClient() *http.Client {
+	if c.httpClient == nil {
+		return http.DefaultClient
+	}
+	return c.httpClient
+}
+
+// getConfigSource returns the source of the otel configs
+func (c *KubeAgentMonitor) getConfigSource() ConfigSource {
+	if c.configSource == nil {
+		return &kubeAPIConfigSource{monitor: c}
+	}
+	return c.configSource
+}
+
 // GetFactories get otel factories for KubeAgent
 func (k *KubeAgent) GetFactories(_ context.Context) (otelcol.Factories, error) {
 	var err error
@@ -202,10 +227,15 @@ func (k *KubeAgent) GetFactories(_ context.Context) (otelcol.Factories, error) {
 }
 
 // ListenForConfigChanges listens for configuration changes for the
-// agent on the Middleware backend and restarts the agent if configuration
+// agent on the config source and restarts the agent if configuration
 // has changed.
 func (c *KubeAgentMonitor) ListenForKubeOtelConfigChanges(ctx context.Context) error {
-	err := c.callRestartStatusAPI(ctx)
+	var err error
+	if _, ok := c.getConfigSource().(*kubeAPIConfigSource); ok {
+		err = c.callRestartStatusAPI(ctx)
+	} else {
+		err = c.syncConfigSource(ctx)
+	}
 	if err != nil {
 		c.logger.Info("error restarting agent on config change",
 			zap.Error(err))
@@ -217,13 +247,11 @@ func (c *KubeAgentMonitor) ListenForKubeOtelConfigChanges(ctx context.Context) e
 // For a particular account
 func (c *KubeAgentMonitor) callRestartStatusAPI(ctx context.Context) error {
 
-	u, err := url.Parse(c.APIURLForConfigCheck)
+	baseURL, err := c.apiURL(apiPathForRestart)
 	if err != nil {
 		return err
 	}
 
-	baseURL := u.JoinPath(apiPathForRestart)
-	baseURL = baseURL.JoinPath(c.APIKey)
 	params := url.Values{}
 	params.Add("platform", "k8s")
 	params.Add("host_id", c.ClusterName)
@@ -233,10 +261,16 @@ func (c *KubeAgentMonitor) callRestartStatusAPI(ctx context.Context) error {
 	// Add Query Parameters to the URL
 	baseURL.RawQuery = params.Encode() // Escape Query Parameters
 	url := baseURL.String()
-	resp, err := http.Get(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	c.setAPIKey(req)
+
+	resp, err := c.getHTTPClient().Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to call restart api for url %s: %w",
-			url, err)
+			c.redactURL(url), c.redactError(err))
 	}
 
 	defer resp.Body.Close()
@@ -267,6 +301,25 @@ func (c *KubeAgentMonitor) callRestartStatusAPI(ctx context.Context) error {
 	return err
 }
 
+// syncConfigSource updates the configmaps from the config source and
+// rollout restarts the components whose config changed.
+func (c *KubeAgentMonitor) syncConfigSource(ctx context.Context) error {
+	for _, componentType := range []ComponentType{DaemonSet, Deployment} {
+		changed, err := c.updateConfigMap(ctx, componentType)
+		if err != nil {
+			return err
+		}
+
+		if changed {
+			c.logger.Info("restarting mw-agent", zap.Stringer("component", componentType))
+			if err := c.rolloutRestart(ctx, componentType); err != nil {
+				return fmt.Errorf("error restarting mw-agent: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
 // restartKubeAgent rewrites the configmaps and rollout restarts agent's data scraping components
 func (c *KubeAgentMonitor) restartKubeAgent(ctx context.Context, componentType ComponentType) error {
 
@@ -326,109 +379,163 @@ func (c *KubeAgentMonitor) rolloutRestart(ctx context.Context, componentType Com
 	return nil
 }
 
-// updateConfigMap gets the latest configmap from Middleware backend and updates the k8s configmap
-// based on component type
+// UpdateConfigMap gets the latest otel config from the config source and
+// updates the k8s configmap based on component type
 func (c *KubeAgentMonitor) UpdateConfigMap(ctx context.Context, componentType ComponentType) error {
+	_, err := c.updateConfigMap(ctx, componentType)
+	return err
+}
 
-	u, err := url.Parse(c.APIURLForConfigCheck)
+// updateConfigMap is UpdateConfigMap which also returns whether the
+// configmap changed.
+func (c *KubeAgentMonitor) updateConfigMap(ctx context.Context, componentType ComponentType) (bool, error) {
+	src, err := c.getConfigSource().FetchConfig(ctx, ConfigRequest{Type: componentType.String()})
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	baseURL := u.JoinPath(apiPathForYAML)
-	baseURL = baseURL.JoinPath(c.APIKey)
-	params := url.Values{}
-	params.Add("platform", "k8s")
-	params.Add("component_type", componentType.String())
-	params.Add("host_id", c.ClusterName)
-	params.Add("cluster", c.ClusterName)
-	params.Add("agent_version", c.Version)
+	// reject unsigned and mis-signed configs before they reach the configmap
+	if err := c.verifySourceConfig(src); err != nil {
+		if trackErr := c.updateAgentTrackStatus(ctx, err); trackErr != nil {
+			c.logger.Error("failed to update agent track status", zap.Error(trackErr))
+		}
+		return false, err
+	}
 
-	// Add Query Parameters to the URL
-	baseURL.RawQuery = params.Encode() // Escape Query Parameters
+	yamlData, err := yaml.Marshal(src.Config)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal api data: %w", err)
+	}
+
+	configMapName := c.DeploymentConfigMap
+	if componentType == DaemonSet {
+		configMapName = c.DaemonsetConfigMap
+	}
 
-	resp, err := http.Get(baseURL.String())
+	// Retrieve the existing ConfigMap
+	existingConfigMap, err := c.Clientset.CoreV1().ConfigMaps(c.AgentNamespace).Get(ctx, configMapName, metav1.GetOptions{})
 	if err != nil {
-		c.logger.Error("failed to call Restart-API", zap.String("url", baseURL.String()), zap.Error(err))
-		return err
+		return false, fmt.Errorf("failed to get configmap: %w", err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("get configuration api returned non-200 status: %d", resp.StatusCode)
+	if existingConfigMap.Data["otel-config"] == string(yamlData) {
+		return false, nil
 	}
 
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
+	// Modify the content of the ConfigMap
+	if existingConfigMap.Data == nil {
+		existingConfigMap.Data = map[string]string{}
+	}
+	existingConfigMap.Data["otel-config"] = string(yamlData)
+
+	// Update the ConfigMap
+	updatedConfigMap, err := c.Clientset.CoreV1().ConfigMaps(c.AgentNamespace).Update(ctx, existingConfigMap, metav1.UpdateOptions{})
 	if err != nil {
-		return fmt.Errorf("failed to read response body: %w", err)
+		return false, fmt.Errorf("failed to update configmap: %w", err)
 	}
 
-	// Unmarshal JSON response into ApiResponse struct
-	var apiResponse apiResponseForYAML
-	if err := json.Unmarshal(body, &apiResponse); err != nil {
-		return fmt.Errorf("failed to unmarshal api response: %w", err)
+	c.logger.Info("ConfigMap updated successfully", zap.String("configmap", updatedConfigMap.Name))
+	return true, nil
+}
+
+// updateAgentTrackStatus reports to Middleware backend that the config of
+// the cluster was rejected for the given reason.
+func (c *KubeAgentMonitor) updateAgentTrackStatus(ctx context.Context, reason error) error {
+	baseURL, err := c.apiURL(apiAgentTrack)
+	if err != nil {
+		return err
 	}
 
-	// Verify API Response
-	if !apiResponse.Status {
-		return fmt.Errorf("failure status from api response for ingestion rules: %t",
-			apiResponse.Status)
+	payloadBytes, err := json.Marshal(TrackingPayload{
+		ID:        uuid.NewString(),
+		Status:    TrackEventConfigRejected,
+		Timestamp: time.Now().UTC(),
+		Metadata: TrackingMetadata{
+			HostID:        c.ClusterName,
+			Platform:      "k8s",
+			AgentVersion:  c.Version,
+			InfraPlatform: fmt.Sprint(InfraPlatformKubernetes),
+			Reason:        reason.Error(),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
 	}
 
-	var apiYAMLConfig map[string]interface{}
-	if len(apiResponse.Config.DaemonSet) == 0 && len(apiResponse.Config.Deployment) == 0 {
-		return fmt.Errorf("failed to get valid response, config docker len: %d, config no docker len: %d",
-			len(apiResponse.Config.Docker), len(apiResponse.Config.NoDocker))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL.String(),
+		bytes.NewReader(payloadBytes))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
 	}
+	req.Header.Set("Content-Type", "application/json")
+	c.setAPIKey(req)
 
-	apiYAMLConfig = apiResponse.Config.Deployment
-	if componentType == DaemonSet {
-		apiYAMLConfig = apiResponse.Config.DaemonSet
+	resp, err := c.getHTTPClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("Agent Track API request failed: %w", c.redactError(err))
 	}
+	defer resp.Body.Close()
 
-	yamlData, err := yaml.Marshal(apiYAMLConfig)
+	if resp.StatusCode != http.StatusOK {
+		return statusError("Agent Track API", resp.StatusCode)
+	}
+	return nil
+}
+
+// fetchConfig calls the get configuration api for the given component type
+// and returns the config of the type along with its signature
+func (c *KubeAgentMonitor) fetchConfig(ctx context.Context, componentType string) (*SourceConfig, error) {
+	baseURL, err := c.apiURL(apiPathForYAML)
 	if err != nil {
-		return fmt.Errorf("failed to marshal api data: %w", err)
+		return nil, err
 	}
 
-	switch componentType {
-	case DaemonSet:
-		// Retrieve the existing ConfigMap
-		existingDaemonsetConfigMap, err := c.Clientset.CoreV1().ConfigMaps(c.AgentNamespace).Get(ctx, c.DaemonsetConfigMap, metav1.GetOptions{})
-		if err != nil {
-			return fmt.Errorf("failed to get configmap: %w", err)
-		}
+	params := url.Values{}
+	params.Add("platform", "k8s")
+	params.Add("component_type", componentType)
+	params.Add("host_id", c.ClusterName)
+	params.Add("cluster", c.ClusterName)
+	params.Add("agent_version", c.Version)
 
-		// Modify the content of the ConfigMap
-		existingDaemonsetConfigMap.Data["otel-config"] = string(yamlData)
+	// Add Query Parameters to the URL
+	baseURL.RawQuery = params.Encode() // Escape Query Parameters
 
-		// Update the ConfigMap
-		updatedConfigMap, err := c.Clientset.CoreV1().ConfigMaps(c.AgentNamespace).Update(ctx, existingDaemonsetConfigMap, metav1.UpdateOptions{})
-		if err != nil {
-			return fmt.Errorf("failed to update configmap: %w", err)
-		}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	c.setAPIKey(req)
 
-		c.logger.Info("ConfigMap updated successfully ", zap.String("configmap", updatedConfigMap.Name))
-	case Deployment:
-		// Retrieve the existing ConfigMap
-		existingDeploymentConfigMap, err := c.Clientset.CoreV1().ConfigMaps(c.AgentNamespace).Get(ctx, c.DeploymentConfigMap, metav1.GetOptions{})
-		if err != nil {
-			return fmt.Errorf("failed to get configmap: %w", err)
-		}
+	resp, err := c.getHTTPClient().Do(req)
+	if err != nil {
+		err = c.redactError(err)
+		c.logger.Error("failed to call Restart-API", zap.String("url", c.redactURL(baseURL.String())), zap.Error(err))
+		return nil, err
+	}
+	defer resp.Body.Close()
 
-		// Modify the content of the ConfigMap
-		existingDeploymentConfigMap.Data["otel-config"] = string(yamlData)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get configuration api returned non-200 status: %d", resp.StatusCode)
+	}
 
-		// Update the ConfigMap
-		updatedDeploymentConfigMap, err := c.Clientset.CoreV1().ConfigMaps(c.AgentNamespace).Update(ctx, existingDeploymentConfigMap, metav1.UpdateOptions{})
-		if err != nil {
-			return fmt.Errorf("failed to update configmap: %w", err)
-		}
+	// Read response body
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
 
-		c.logger.Info("ConfigMap updated successfully", zap.String("configmap", updatedDeploymentConfigMap.Name))
+	// Unmarshal JSON response into ApiResponse struct
+	var apiResponse apiResponseForYAML
+	if err := json.Unmarshal(body, &apiResponse); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal api response: %w", err)
 	}
 
-	return nil
+	src, err := apiResponse.sourceConfig(componentType, "")
+	if err != nil {
+		return nil, err
+	}
 
+	src.Payload = body
+	src.Signature = resp.Header.Get(configSignatureHeader)
+	return src, nil
 }
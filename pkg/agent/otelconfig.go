@@ -0,0 +1,69 @@
+package agent
+
+// The helpers below read and rewrite the otel config decoded into a
+// map[string]interface{}, see parseConfig.
+
+// configSection returns the map at the given keys of config, nil if there
+// is none.
+func configSection(config map[string]interface{}, keys ...string) map[string]interface{} {
+	for _, key := range keys {
+		config, _ = config[key].(map[string]interface{})
+	}
+	return config
+}
+
+// componentNames returns the component names in the given list of a
+// pipeline or the service extensions.
+func componentNames(value interface{}) []string {
+	var names []string
+	switch v := value.(type) {
+	case []interface{}:
+		for _, name := range v {
+			if s, ok := name.(string); ok {
+				names = append(names, s)
+			}
+		}
+	case []string:
+		names = append(names, v...)
+	}
+	return names
+}
+
+// removeComponentReferences removes the given components from the service
+// extensions and the pipelines. Pipelines left without receivers or
+// exporters are removed.
+func removeComponentReferences(config map[string]interface{}, removed map[string]struct{}) {
+	filter := func(value interface{}) []interface{} {
+		names := []interface{}{}
+		for _, name := range componentNames(value) {
+			if _, ok := removed[name]; !ok {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+
+	service := configSection(config, Service)
+	if _, ok := service["extensions"]; ok {
+		service["extensions"] = filter(service["extensions"])
+	}
+
+	pipelines := configSection(config, Service, Pipelines)
+	for name, value := range pipelines {
+		pipeline, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		for _, key := range []string{Receivers, "processors", "exporters"} {
+			if _, ok := pipeline[key]; ok {
+				pipeline[key] = filter(pipeline[key])
+			}
+		}
+
+		if len(componentNames(pipeline[Receivers])) == 0 ||
+			len(componentNames(pipeline["exporters"])) == 0 {
+			delete(pipelines, name)
+		}
+	}
+}
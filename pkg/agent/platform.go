@@ -0,0 +1,202 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// PlatformEnv is the view of the host which the infra platform detectors
+// inspect, so that the filesystem and the environment can be faked.
+type PlatformEnv struct {
+	// Getenv returns the value of the environment variable
+	Getenv func(key string) string
+	// FS is the root filesystem of the host. Paths are unrooted, e.g.
+	// proc/self/cgroup.
+	FS fs.FS
+}
+
+// HostPlatformEnv returns the PlatformEnv of the host the agent runs on.
+func HostPlatformEnv() PlatformEnv {
+	return PlatformEnv{
+		Getenv: os.Getenv,
+		FS:     os.DirFS("/"),
+	}
+}
+
+// exists checks whether the given absolute path exists.
+func (e PlatformEnv) exists(path string) bool {
+	_, err := fs.Stat(e.FS, strings.TrimPrefix(path, "/"))
+	return err == nil
+}
+
+// readFile returns the trimmed content of the given absolute path, empty if
+// it can't be read.
+func (e PlatformEnv) readFile(path string) string {
+	data, err := fs.ReadFile(e.FS, strings.TrimPrefix(path, "/"))
+	if err != nil {
+		return ""
+	}
+	return string(bytes.TrimSpace(data))
+}
+
+// InfraPlatformDetector returns the platform of env, or false if env is not
+// on the platform of the detector.
+type InfraPlatformDetector func(env PlatformEnv) (InfraPlatform, bool)
+
+// InfraPlatformDetectors are the detectors used by DetectInfraPlatform. They
+// are tried in order and the first match wins, so the container runtimes
+// come before the cloud platforms they may run on.
+var InfraPlatformDetectors = []InfraPlatformDetector{
+	detectECS,
+	detectCycleIO,
+	detectNomad,
+	detectDocker,
+	detectSystemdNspawn,
+	detectLXC,
+	detectManagedKubernetesNode,
+}
+
+// DetectInfraPlatform returns the infra platform of the host the agent runs
+// on, InfraPlatformInstance if no detector matches.
+func DetectInfraPlatform() InfraPlatform {
+	return detectInfraPlatform(HostPlatformEnv(), InfraPlatformDetectors)
+}
+
+func detectInfraPlatform(env PlatformEnv, detectors []InfraPlatformDetector) InfraPlatform {
+	for _, detect := range detectors {
+		if platform, ok := detect(env); ok {
+			return platform
+		}
+	}
+	return InfraPlatformInstance
+}
+
+func detectECS(env PlatformEnv) (InfraPlatform, bool) {
+	switch env.Getenv("AWS_EXECUTION_ENV") {
+	case "AWS_ECS_EC2":
+		return InfraPlatformECSEC2, true
+	case "AWS_ECS_FARGATE":
+		return InfraPlatformECSFargate, true
+	}
+	return InfraPlatformInstance, false
+}
+
+func detectCycleIO(env PlatformEnv) (InfraPlatform, bool) {
+	return InfraPlatformCycleIO, env.Getenv("CYCLE_INSTANCE_ID") != ""
+}
+
+func detectNomad(env PlatformEnv) (InfraPlatform, bool) {
+	// set by Nomad in every task of an allocation
+	return InfraPlatformNomad, env.Getenv("NOMAD_ALLOC_ID") != ""
+}
+
+func detectDocker(env PlatformEnv) (InfraPlatform, bool) {
+	if env.exists("/.dockerenv") {
+		return InfraPlatformDocker, true
+	}
+
+	// cgroup v1 paths, e.g. 12:memory:/docker/<id>
+	cgroup := env.readFile("/proc/self/cgroup")
+	if strings.Contains(cgroup, "/docker/") || strings.Contains(cgroup, "/docker-") {
+		return InfraPlatformDocker, true
+	}
+
+	// with cgroup v2, the cgroup path is /, but docker still bind mounts
+	// /etc/hostname from its containers directory
+	scanner := bufio.NewScanner(strings.NewReader(env.readFile("/proc/self/mountinfo")))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 4 && fields[4] == "/etc/hostname" &&
+			strings.Contains(fields[3], "/docker/containers/") {
+			return InfraPlatformDocker, true
+		}
+	}
+
+	return InfraPlatformInstance, false
+}
+
+// containerManager returns the container manager which runs the agent, as
+// set by systemd and LXC, e.g. lxc or systemd-nspawn.
+func containerManager(env PlatformEnv) string {
+	if container := env.Getenv("container"); container != "" {
+		return container
+	}
+	return env.readFile("/run/systemd/container")
+}
+
+func detectSystemdNspawn(env PlatformEnv) (InfraPlatform, bool) {
+	return InfraPlatformSystemdNspawn, containerManager(env) == "systemd-nspawn"
+}
+
+func detectLXC(env PlatformEnv) (InfraPlatform, bool) {
+	if strings.HasPrefix(containerManager(env), "lxc") || env.exists("/dev/lxd/sock") {
+		return InfraPlatformLXC, true
+	}
+
+	cgroup := env.readFile("/proc/self/cgroup")
+	if strings.Contains(cgroup, "/lxc/") || strings.Contains(cgroup, "/lxc.payload") {
+		return InfraPlatformLXC, true
+	}
+
+	return InfraPlatformInstance, false
+}
+
+// Cloud providers as detected by cloudProvider
+const (
+	cloudProviderAWS   = "aws"
+	cloudProviderGCP   = "gcp"
+	cloudProviderAzure = "azure"
+)
+
+// azureChassisAssetTag is the DMI chassis asset tag of the Azure VMs
+const azureChassisAssetTag = "7783-7084-3265-9085-8269-3286-77"
+
+// cloudProvider returns the cloud provider of the VM the agent runs on as
+// per its DMI data, empty if unknown.
+func cloudProvider(env PlatformEnv) string {
+	dmi := func(name string) string {
+		return strings.ToLower(env.readFile("/sys/class/dmi/id/" + name))
+	}
+
+	switch {
+	case strings.Contains(dmi("sys_vendor"), "amazon") ||
+		strings.Contains(dmi("bios_vendor"), "amazon") ||
+		strings.Contains(dmi("bios_version"), "amazon"):
+		return cloudProviderAWS
+	case dmi("product_name") == "google compute engine" || dmi("sys_vendor") == "google":
+		return cloudProviderGCP
+	case dmi("chassis_asset_tag") == azureChassisAssetTag:
+		return cloudProviderAzure
+	}
+	return ""
+}
+
+// detectManagedKubernetesNode detects the nodes of the managed Kubernetes
+// services, on which the agent runs next to the kubelet.
+func detectManagedKubernetesNode(env PlatformEnv) (InfraPlatform, bool) {
+	switch {
+	case env.exists("/etc/eks/bootstrap.sh"):
+		return InfraPlatformEKS, true
+	case env.exists("/home/kubernetes/bin/kubelet"):
+		return InfraPlatformGKE, true
+	case env.exists("/etc/kubernetes/azure.json"):
+		return InfraPlatformAKS, true
+	}
+
+	if !env.exists("/var/lib/kubelet") {
+		return InfraPlatformInstance, false
+	}
+
+	switch cloudProvider(env) {
+	case cloudProviderAWS:
+		return InfraPlatformEKS, true
+	case cloudProviderGCP:
+		return InfraPlatformGKE, true
+	case cloudProviderAzure:
+		return InfraPlatformAKS, true
+	}
+	return InfraPlatformInstance, false
+}
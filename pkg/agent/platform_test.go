@@ -0,0 +1,166 @@
+package agent
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectInfraPlatform(t *testing.T) {
+	file := func(data string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte(data)}
+	}
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		fs   fstest.MapFS
+		want InfraPlatform
+	}{
+		{
+			name: "instance",
+			fs:   fstest.MapFS{"proc/self/cgroup": file("0::/system.slice/mw-agent.service\n")},
+			want: InfraPlatformInstance,
+		},
+		{
+			name: "ecs fargate",
+			env:  map[string]string{"AWS_EXECUTION_ENV": "AWS_ECS_FARGATE"},
+			fs:   fstest.MapFS{".dockerenv": file("")},
+			want: InfraPlatformECSFargate,
+		},
+		{
+			name: "ecs ec2",
+			env:  map[string]string{"AWS_EXECUTION_ENV": "AWS_ECS_EC2"},
+			want: InfraPlatformECSEC2,
+		},
+		{
+			name: "cycleio",
+			env:  map[string]string{"CYCLE_INSTANCE_ID": "abc"},
+			want: InfraPlatformCycleIO,
+		},
+		{
+			name: "nomad",
+			env:  map[string]string{"NOMAD_ALLOC_ID": "5a1b"},
+			fs:   fstest.MapFS{".dockerenv": file("")},
+			want: InfraPlatformNomad,
+		},
+		{
+			name: "docker dockerenv",
+			fs:   fstest.MapFS{".dockerenv": file("")},
+			want: InfraPlatformDocker,
+		},
+		{
+			name: "docker cgroup v1",
+			fs:   fstest.MapFS{"proc/self/cgroup": file("12:memory:/docker/3f2a\n")},
+			want: InfraPlatformDocker,
+		},
+		{
+			name: "docker cgroup v2",
+			fs: fstest.MapFS{
+				"proc/self/cgroup": file("0::/\n"),
+				"proc/self/mountinfo": file("714 702 259:1 /var/lib/docker/containers/3f2a/hostname " +
+					"/etc/hostname rw,relatime - ext4 /dev/nvme0n1p1 rw\n"),
+			},
+			want: InfraPlatformDocker,
+		},
+		{
+			name: "docker host",
+			fs: fstest.MapFS{
+				"proc/self/cgroup": file("0::/system.slice/mw-agent.service\n"),
+				"proc/self/mountinfo": file("714 702 0:55 / /var/lib/docker/overlay2/3f2a/merged " +
+					"rw,relatime - overlay overlay rw\n"),
+			},
+			want: InfraPlatformInstance,
+		},
+		{
+			name: "systemd-nspawn",
+			fs:   fstest.MapFS{"run/systemd/container": file("systemd-nspawn\n")},
+			want: InfraPlatformSystemdNspawn,
+		},
+		{
+			name: "lxc env",
+			env:  map[string]string{"container": "lxc"},
+			want: InfraPlatformLXC,
+		},
+		{
+			name: "lxd",
+			fs:   fstest.MapFS{"dev/lxd/sock": file("")},
+			want: InfraPlatformLXC,
+		},
+		{
+			name: "lxc cgroup",
+			fs:   fstest.MapFS{"proc/self/cgroup": file("0::/lxc.payload.web/init.scope\n")},
+			want: InfraPlatformLXC,
+		},
+		{
+			name: "eks bootstrap",
+			fs:   fstest.MapFS{"etc/eks/bootstrap.sh": file("")},
+			want: InfraPlatformEKS,
+		},
+		{
+			name: "eks dmi",
+			fs: fstest.MapFS{
+				"var/lib/kubelet/kubeconfig":  file(""),
+				"sys/class/dmi/id/sys_vendor": file("Amazon EC2\n"),
+			},
+			want: InfraPlatformEKS,
+		},
+		{
+			name: "gke",
+			fs: fstest.MapFS{
+				"var/lib/kubelet/kubeconfig":    file(""),
+				"sys/class/dmi/id/product_name": file("Google Compute Engine\n"),
+			},
+			want: InfraPlatformGKE,
+		},
+		{
+			name: "aks",
+			fs: fstest.MapFS{
+				"var/lib/kubelet/kubeconfig":         file(""),
+				"sys/class/dmi/id/chassis_asset_tag": file(azureChassisAssetTag + "\n"),
+			},
+			want: InfraPlatformAKS,
+		},
+		{
+			// a cloud VM without kubelet is an instance
+			name: "ec2",
+			fs:   fstest.MapFS{"sys/class/dmi/id/sys_vendor": file("Amazon EC2\n")},
+			want: InfraPlatformInstance,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := PlatformEnv{
+				Getenv: func(key string) string { return tt.env[key] },
+				FS:     tt.fs,
+			}
+			if env.FS == nil {
+				env.FS = fstest.MapFS{}
+			}
+
+			p := detectInfraPlatform(env, InfraPlatformDetectors)
+			assert.Equal(t, tt.want, p, p.String())
+		})
+	}
+}
+
+func TestDetectInfraPlatformCustomDetector(t *testing.T) {
+	env := PlatformEnv{
+		Getenv: func(string) string { return "" },
+		FS:     fstest.MapFS{},
+	}
+
+	custom := func(PlatformEnv) (InfraPlatform, bool) { return InfraPlatformNomad, true }
+	assert.Equal(t, InfraPlatformNomad, detectInfraPlatform(env, []InfraPlatformDetector{detectDocker, custom}))
+	assert.Equal(t, InfraPlatformInstance, detectInfraPlatform(env, nil))
+}
+
+func TestInfraPlatformString(t *testing.T) {
+	for p := InfraPlatformInstance; p <= InfraPlatformLXC; p++ {
+		parsed, err := ParseInfraPlatform(p.String())
+		assert.NoError(t, err)
+		assert.Equal(t, p, parsed)
+	}
+}
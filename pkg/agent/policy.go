@@ -0,0 +1,327 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"go.opentelemetry.io/collector/confmap"
+	"go.uber.org/zap"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Policy modes, i.e. what the agent does with a config which violates the
+// local policy
+const (
+	// PolicyModeStrip removes the violating components from the config
+	PolicyModeStrip = "strip"
+	// PolicyModeReject rejects the whole config as invalid
+	PolicyModeReject = "reject"
+)
+
+var ErrInvalidPolicy = errors.New("invalid policy")
+
+// ComponentPolicy restricts the component types in a section of the otel
+// config, e.g. exporters.
+type ComponentPolicy struct {
+	// Allowed lists the only component types which may be configured.
+	// Empty allows all types.
+	Allowed []string `yaml:"allowed"`
+	// Forbidden lists the component types which may not be configured
+	Forbidden []string `yaml:"forbidden"`
+}
+
+// Policy is the local policy of the host owner which constrains the otel
+// configs applied by the agent, whichever their source. Unlike the otel
+// config, it can't be changed remotely.
+type Policy struct {
+	// Mode is PolicyModeStrip, the default, or PolicyModeReject
+	Mode       string          `yaml:"mode"`
+	Receivers  ComponentPolicy `yaml:"receivers"`
+	Processors ComponentPolicy `yaml:"processors"`
+	Exporters  ComponentPolicy `yaml:"exporters"`
+	Connectors ComponentPolicy `yaml:"connectors"`
+	Extensions ComponentPolicy `yaml:"extensions"`
+	// FilelogInclude lists the globs, e.g. /var/log/**, which the include
+	// paths of the filelog receivers need to match. Empty allows all paths.
+	FilelogInclude []string `yaml:"filelog_include"`
+	// MaxQueueSize is the maximum sending_queue::queue_size of the
+	// exporters. Larger queues are lowered to it. Zero means no maximum.
+	MaxQueueSize int `yaml:"max_queue_size"`
+}
+
+// PolicyViolation is a policy rule fired by a component of an otel config.
+type PolicyViolation struct {
+	// Rule is the policy rule, e.g. exporters.forbidden
+	Rule string `json:"rule"`
+	// Component is the location of the component, e.g. exporters::file
+	Component string `json:"component"`
+	Message   string `json:"message"`
+}
+
+func (v PolicyViolation) String() string {
+	return fmt.Sprintf("%s: %s (%s)", v.Component, v.Message, v.Rule)
+}
+
+// LoadPolicy reads the local policy from the given YAML file.
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var p Policy
+	if err := yaml.UnmarshalStrict(data, &p); err != nil {
+		return nil, fmt.Errorf("%w %s: %v", ErrInvalidPolicy, path, err)
+	}
+
+	if err := p.validate(); err != nil {
+		return nil, fmt.Errorf("%w %s: %v", ErrInvalidPolicy, path, err)
+	}
+
+	return &p, nil
+}
+
+func (p *Policy) validate() error {
+	switch p.Mode {
+	case "", PolicyModeStrip, PolicyModeReject:
+	default:
+		return fmt.Errorf("unknown mode %q", p.Mode)
+	}
+
+	for _, glob := range p.FilelogInclude {
+		if !doublestar.ValidatePattern(glob) {
+			return fmt.Errorf("invalid filelog_include glob %q", glob)
+		}
+	}
+
+	if p.MaxQueueSize < 0 {
+		return fmt.Errorf("invalid max_queue_size %d", p.MaxQueueSize)
+	}
+
+	return nil
+}
+
+// componentPolicies maps the config sections to their policy
+func (p *Policy) componentPolicies() map[string]ComponentPolicy {
+	return map[string]ComponentPolicy{
+		Receivers:    p.Receivers,
+		"processors": p.Processors,
+		"exporters":  p.Exporters,
+		"connectors": p.Connectors,
+		"extensions": p.Extensions,
+	}
+}
+
+// apply removes the components of config which violate the policy, along
+// with their references in the service, and lowers the queue sizes above
+// the maximum. It returns the violations found.
+func (p *Policy) apply(config map[string]interface{}) []PolicyViolation {
+	var violations []PolicyViolation
+	removed := map[string]struct{}{}
+	policies := p.componentPolicies()
+
+	for _, kind := range configDiffKinds {
+		components := configSection(config, kind)
+		names := make([]string, 0, len(components))
+		for name := range components {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			location := kind + confmap.KeyDelimiter + name
+			componentType, _, _ := strings.Cut(name, "/")
+
+			violation := p.checkComponent(kind, policies[kind], componentType, components[name])
+			if violation != nil {
+				violation.Component = location
+				violations = append(violations, *violation)
+				delete(components, name)
+				removed[name] = struct{}{}
+				continue
+			}
+
+			if kind == "exporters" && p.MaxQueueSize > 0 {
+				if violation := p.limitQueueSize(components[name]); violation != nil {
+					violation.Component = location
+					violations = append(violations, *violation)
+				}
+			}
+		}
+	}
+
+	if len(removed) > 0 {
+		removeComponentReferences(config, removed)
+	}
+
+	return violations
+}
+
+// checkComponent returns the violation of the policy by the given
+// component, if any.
+func (p *Policy) checkComponent(kind string, policy ComponentPolicy,
+	componentType string, componentConfig interface{}) *PolicyViolation {
+	for _, forbidden := range policy.Forbidden {
+		if componentType == forbidden {
+			return &PolicyViolation{
+				Rule:    kind + ".forbidden",
+				Message: fmt.Sprintf("%s is forbidden", componentType),
+			}
+		}
+	}
+
+	if len(policy.Allowed) > 0 {
+		allowed := false
+		for _, t := range policy.Allowed {
+			allowed = allowed || componentType == t
+		}
+		if !allowed {
+			return &PolicyViolation{
+				Rule:    kind + ".allowed",
+				Message: fmt.Sprintf("%s is not allowed", componentType),
+			}
+		}
+	}
+
+	if kind == Receivers && componentType == "filelog" && len(p.FilelogInclude) > 0 {
+		receiverConfig, _ := componentConfig.(map[string]interface{})
+		paths, ok := filelogIncludePaths(receiverConfig["include"])
+		if !ok {
+			return &PolicyViolation{
+				Rule:    "filelog_include",
+				Message: fmt.Sprintf("include of type %T can't be checked", receiverConfig["include"]),
+			}
+		}
+		for _, path := range paths {
+			if !p.filelogPathAllowed(path) {
+				return &PolicyViolation{
+					Rule:    "filelog_include",
+					Message: fmt.Sprintf("include path %s is not allowed", path),
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// filelogIncludePaths returns the paths of the include setting of a
+// filelog receiver. Like the collector, it accepts a comma separated string
+// as well as a list. It returns false if include has any other shape.
+func filelogIncludePaths(include interface{}) ([]string, bool) {
+	switch v := include.(type) {
+	case nil:
+		return nil, true
+	case string:
+		if v == "" {
+			return nil, true
+		}
+		return strings.Split(v, ","), true
+	case []string:
+		return v, true
+	case []interface{}:
+		paths := make([]string, 0, len(v))
+		for _, path := range v {
+			s, ok := path.(string)
+			if !ok {
+				return nil, false
+			}
+			paths = append(paths, s)
+		}
+		return paths, true
+	}
+	return nil, false
+}
+
+// filelogPathAllowed checks whether the given include path, itself a glob,
+// is within one of the allowed globs.
+func (p *Policy) filelogPathAllowed(path string) bool {
+	// resolve .. so that /var/log/../../etc/shadow is not within /var/log/**
+	path = filepath.ToSlash(filepath.Clean(path))
+	for _, glob := range p.FilelogInclude {
+		if ok, _ := doublestar.Match(filepath.ToSlash(glob), path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// limitQueueSize lowers the queue size of the given exporter config to the
+// maximum.
+func (p *Policy) limitQueueSize(exporterConfig interface{}) *PolicyViolation {
+	exporter, _ := exporterConfig.(map[string]interface{})
+	queue, ok := exporter["sending_queue"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	size, ok := queue["queue_size"]
+	if !ok {
+		return nil
+	}
+
+	// sizes which are not plain numbers, e.g. ${env:QUEUE_SIZE}, can't be
+	// checked and are lowered as well
+	if n, isNumber := queueSizeNumber(size); isNumber && n <= float64(p.MaxQueueSize) {
+		return nil
+	}
+
+	queue["queue_size"] = p.MaxQueueSize
+	return &PolicyViolation{
+		Rule:    "max_queue_size",
+		Message: fmt.Sprintf("queue_size %v lowered to %d", size, p.MaxQueueSize),
+	}
+}
+
+// queueSizeNumber returns the given queue_size as a number, if it is one.
+// Configs decoded from YAML hold ints, while the configs decoded from the
+// JSON response of Middleware backend hold float64s.
+func queueSizeNumber(size interface{}) (float64, bool) {
+	switch n := size.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// applyPolicy enforces the local policy, if any, on the given otel config.
+// The violations are logged and kept in the agent state. In reject mode, a
+// config with violations is rejected with ErrInvalidConfig.
+func (c *HostAgent) applyPolicy(config map[string]interface{}) error {
+	if c.policy == nil {
+		return nil
+	}
+
+	violations := c.policy.apply(config)
+	c.state.setPolicyViolations(violations)
+	if len(violations) == 0 {
+		return nil
+	}
+
+	rules := make([]string, 0, len(violations))
+	for _, v := range violations {
+		c.logger.Warn("otel config violates local policy",
+			zap.String("rule", v.Rule),
+			zap.String("component", v.Component),
+			zap.String("reason", v.Message))
+		rules = append(rules, v.String())
+	}
+
+	if c.policy.Mode == PolicyModeReject {
+		return fmt.Errorf("%w: config violates local policy: %s", ErrInvalidConfig,
+			strings.Join(rules, "; "))
+	}
+
+	return nil
+}
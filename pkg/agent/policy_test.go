@@ -0,0 +1,219 @@
+package agent
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+const policyTestConfig = `receivers:
+  otlp:
+    protocols:
+      grpc:
+        endpoint: 127.0.0.1:0
+  filelog:
+    include: [/var/log/syslog]
+  filelog/secrets:
+    include: [/var/log/../../etc/shadow]
+exporters:
+  otlp:
+    endpoint: example.com:443
+    sending_queue:
+      queue_size: 100000
+  file:
+    path: /tmp/out.json
+extensions:
+  health_check: {}
+service:
+  extensions: [health_check]
+  pipelines:
+    logs:
+      receivers: [filelog, filelog/secrets]
+      exporters: [otlp, file]
+    logs/secrets:
+      receivers: [filelog/secrets]
+      exporters: [otlp]
+    metrics:
+      receivers: [otlp]
+      exporters: [file]
+`
+
+func TestLoadPolicy(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		policy  string
+		wantErr bool
+	}{
+		{name: "valid", policy: "mode: reject\nexporters:\n  forbidden: [file]\nfilelog_include: [/var/log/**]\n"},
+		{name: "unknown mode", policy: "mode: warn\n", wantErr: true},
+		{name: "unknown field", policy: "exporter:\n  forbidden: [file]\n", wantErr: true},
+		{name: "invalid glob", policy: "filelog_include: ['/var/log/[']\n", wantErr: true},
+		{name: "negative queue size", policy: "max_queue_size: -1\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "policy.yaml")
+			require.NoError(t, os.WriteFile(path, []byte(tt.policy), 0o644))
+
+			_, err := LoadPolicy(path)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidPolicy)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestPolicyApply(t *testing.T) {
+	config, err := parseConfig([]byte(policyTestConfig))
+	require.NoError(t, err)
+
+	policy := &Policy{
+		Exporters:      ComponentPolicy{Forbidden: []string{"file", "kafka"}},
+		Extensions:     ComponentPolicy{Allowed: []string{"pprof"}},
+		FilelogInclude: []string{"/var/log/**"},
+		MaxQueueSize:   5000,
+	}
+
+	violations := policy.apply(config)
+	assert.Equal(t, []PolicyViolation{
+		{Rule: "filelog_include", Component: "receivers::filelog/secrets",
+			Message: "include path /var/log/../../etc/shadow is not allowed"},
+		{Rule: "exporters.forbidden", Component: "exporters::file", Message: "file is forbidden"},
+		{Rule: "max_queue_size", Component: "exporters::otlp", Message: "queue_size 100000 lowered to 5000"},
+		{Rule: "extensions.allowed", Component: "extensions::health_check", Message: "health_check is not allowed"},
+	}, violations)
+
+	assert.Equal(t, map[string]interface{}{
+		Receivers: map[string]interface{}{
+			"otlp":    configSection(config, Receivers, "otlp"),
+			"filelog": configSection(config, Receivers, "filelog"),
+		},
+		"exporters": map[string]interface{}{
+			"otlp": map[string]interface{}{
+				"endpoint":      "example.com:443",
+				"sending_queue": map[string]interface{}{"queue_size": 5000},
+			},
+		},
+		"extensions": map[string]interface{}{},
+		Service: map[string]interface{}{
+			"extensions": []interface{}{},
+			// logs/secrets and metrics are left without receivers or exporters
+			Pipelines: map[string]interface{}{
+				"logs": map[string]interface{}{
+					Receivers:   []interface{}{"filelog"},
+					"exporters": []interface{}{"otlp"},
+				},
+			},
+		},
+	}, config)
+}
+
+func TestPolicyApplyJSONConfig(t *testing.T) {
+	// the config from Middleware backend is decoded from JSON, so its
+	// numbers are float64s
+	var config map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"exporters": {
+			"otlp": {"sending_queue": {"queue_size": 100}},
+			"otlp/2": {"sending_queue": {"queue_size": 5000}},
+			"otlp/3": {"sending_queue": {"queue_size": 100000}}
+		}
+	}`), &config))
+
+	policy := &Policy{MaxQueueSize: 5000}
+	violations := policy.apply(config)
+	assert.Equal(t, []PolicyViolation{
+		{Rule: "max_queue_size", Component: "exporters::otlp/3", Message: "queue_size 100000 lowered to 5000"},
+	}, violations)
+
+	assert.Equal(t, float64(100), configSection(config, "exporters", "otlp", "sending_queue")["queue_size"])
+	assert.Equal(t, float64(5000), configSection(config, "exporters", "otlp/2", "sending_queue")["queue_size"])
+	assert.Equal(t, 5000, configSection(config, "exporters", "otlp/3", "sending_queue")["queue_size"])
+}
+
+func TestPolicyFilelogIncludeShapes(t *testing.T) {
+	policy := &Policy{FilelogInclude: []string{"/var/log/**"}}
+
+	tests := []struct {
+		name    string
+		include interface{}
+		allowed bool
+	}{
+		{name: "list", include: []interface{}{"/var/log/syslog"}, allowed: true},
+		{name: "string", include: "/var/log/syslog", allowed: true},
+		// the collector splits a string on commas
+		{name: "string outside", include: "/etc/shadow"},
+		{name: "comma separated", include: "/var/log/syslog,/etc/shadow"},
+		{name: "non-string entry", include: []interface{}{"/var/log/syslog", 1}},
+		{name: "map", include: map[string]interface{}{"path": "/var/log/syslog"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := map[string]interface{}{
+				Receivers: map[string]interface{}{
+					"filelog": map[string]interface{}{"include": tt.include},
+				},
+			}
+
+			violations := policy.apply(config)
+			if tt.allowed {
+				assert.Empty(t, violations)
+				assert.Contains(t, config[Receivers], "filelog")
+				return
+			}
+			require.Len(t, violations, 1)
+			assert.Equal(t, "filelog_include", violations[0].Rule)
+			assert.NotContains(t, config[Receivers], "filelog")
+		})
+	}
+}
+
+func TestHostAgentPolicy(t *testing.T) {
+	policyFile := filepath.Join(t.TempDir(), "policy.yaml")
+	require.NoError(t, os.WriteFile(policyFile, []byte("exporters:\n  forbidden: [file]\n"), 0o644))
+
+	newAgent := func(policyFile string) (*HostAgent, error) {
+		return NewHostAgent(HostConfig{
+			BaseConfig: BaseConfig{
+				AgentFeatures: AgentFeatures{
+					MetricCollection: true,
+					LogCollection:    true,
+				},
+			},
+			PolicyFile: policyFile,
+		}, zapcore.NewNopCore())
+	}
+
+	agent, err := newAgent(policyFile)
+	require.NoError(t, err)
+
+	config, err := parseConfig([]byte(policyTestConfig))
+	require.NoError(t, err)
+	data, _, err := agent.renderConfig(config, nil)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "/tmp/out.json")
+	assert.Len(t, agent.Status().PolicyViolations, 1)
+
+	require.NoError(t, os.WriteFile(policyFile, []byte("mode: reject\nexporters:\n  forbidden: [file]\n"), 0o644))
+	agent, err = newAgent(policyFile)
+	require.NoError(t, err)
+
+	config, err = parseConfig([]byte(policyTestConfig))
+	require.NoError(t, err)
+	_, _, err = agent.renderConfig(config, nil)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.Contains(t, err.Error(), "exporters::file: file is forbidden (exporters.forbidden)")
+
+	_, err = newAgent(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,301 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// redactedSecret replaces the secrets in the agent logs
+const redactedSecret = "REDACTED"
+
+// maskSecret masks s for logging. Only the last 4 characters of long
+// secrets are kept so that the secret can still be identified.
+func maskSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	if len(s) < 16 {
+		return redactedSecret
+	}
+	return redactedSecret + "-" + s[len(s)-4:]
+}
+
+// Secrets returns the values in the config that must not be logged.
+func (c BaseConfig) Secrets() []string {
+	var secrets []string
+	if c.APIKey != "" {
+		secrets = append(secrets, c.APIKey)
+	}
+
+	if u, err := url.Parse(c.HTTPClient.ProxyURL); err == nil && u.User != nil {
+		if password, ok := u.User.Password(); ok && password != "" {
+			secrets = append(secrets, password)
+		}
+	}
+	return secrets
+}
+
+// sensitiveConfigKeys are the otel config keys whose values are always
+// redacted by redactConfig
+var sensitiveConfigKeys = []string{
+	"password",
+	"token",
+	"secret",
+	"api_key",
+	"apikey",
+	"authorization",
+}
+
+func isSensitiveConfigKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, sensitiveKey := range sensitiveConfigKeys {
+		if strings.Contains(key, sensitiveKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactConfig returns a copy of the given otel config without the given
+// secrets and the values of the sensitive keys.
+func redactConfig(config map[string]interface{}, secrets []string) map[string]interface{} {
+	var oldnew []string
+	for _, secret := range secrets {
+		if secret != "" {
+			oldnew = append(oldnew, secret, redactedSecret)
+		}
+	}
+	replacer := strings.NewReplacer(oldnew...)
+
+	var redact func(key string, value interface{}) interface{}
+	redact = func(key string, value interface{}) interface{} {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			m := make(map[string]interface{}, len(v))
+			for k, val := range v {
+				m[k] = redact(k, val)
+			}
+			return m
+		case []interface{}:
+			l := make([]interface{}, len(v))
+			for i, val := range v {
+				l[i] = redact(key, val)
+			}
+			return l
+		case string:
+			if isSensitiveConfigKey(key) {
+				return redactedSecret
+			}
+			return replacer.Replace(v)
+		}
+		return value
+	}
+
+	return redact("", config).(map[string]interface{})
+}
+
+// redactingCore is a zapcore.Core which removes the given secrets from the
+// message and the fields of every log entry, including the fields of
+// objects, arrays and reflected values such as header maps.
+type redactingCore struct {
+	zapcore.Core
+	replacer *strings.Replacer
+	// encoder encodes the fields encoded by zap, so that they are looked
+	// for the encoded secrets before they are redacted
+	encoder        zapcore.Encoder
+	encodedSecrets [][]byte
+}
+
+// NewRedactingCore wraps core so that the given secrets never reach the
+// logs written by it. Empty secrets are ignored.
+func NewRedactingCore(core zapcore.Core, secrets ...string) zapcore.Core {
+	var oldnew []string
+	for _, secret := range secrets {
+		if secret != "" {
+			oldnew = append(oldnew, secret, redactedSecret)
+		}
+	}
+
+	if len(oldnew) == 0 {
+		return core
+	}
+
+	encoder := zapcore.NewJSONEncoder(zapcore.EncoderConfig{})
+	var encodedSecrets [][]byte
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+
+		// the secret may be escaped in the encoded fields, e.g. if it has
+		// quotes
+		encodedSecrets = append(encodedSecrets, []byte(secret))
+		if encoded := encodeSecret(encoder, secret); encoded != secret {
+			encodedSecrets = append(encodedSecrets, []byte(encoded))
+		}
+	}
+
+	return &redactingCore{
+		Core:           core,
+		replacer:       strings.NewReplacer(oldnew...),
+		encoder:        encoder,
+		encodedSecrets: encodedSecrets,
+	}
+}
+
+// encodeSecret returns secret as it is written in a JSON string by encoder.
+func encodeSecret(encoder zapcore.Encoder, secret string) string {
+	buf, err := encoder.EncodeEntry(zapcore.Entry{}, []zapcore.Field{zap.String("", secret)})
+	if err != nil {
+		return secret
+	}
+	defer buf.Free()
+
+	encoded := strings.TrimSpace(buf.String())
+	encoded = strings.TrimPrefix(encoded, `{"":"`)
+	return strings.TrimSuffix(encoded, `"}`)
+}
+
+// redactingOption returns a zap option which removes the given secrets
+// from the logs.
+func redactingOption(secrets ...string) zap.Option {
+	return zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return NewRedactingCore(core, secrets...)
+	})
+}
+
+func (c *redactingCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactingCore{
+		Core:           c.Core.With(c.redactFields(fields)),
+		replacer:       c.replacer,
+		encoder:        c.encoder,
+		encodedSecrets: c.encodedSecrets,
+	}
+}
+
+func (c *redactingCore) Check(entry zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return ce.AddCore(entry, c)
+	}
+	return ce
+}
+
+func (c *redactingCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	entry.Message = c.replacer.Replace(entry.Message)
+	entry.Stack = c.replacer.Replace(entry.Stack)
+	return c.Core.Write(entry, c.redactFields(fields))
+}
+
+func (c *redactingCore) redactFields(fields []zapcore.Field) []zapcore.Field {
+	redacted := make([]zapcore.Field, len(fields))
+	for i, field := range fields {
+		switch field.Type {
+		case zapcore.StringType:
+			field.String = c.replacer.Replace(field.String)
+		case zapcore.ErrorType:
+			if err, ok := field.Interface.(error); ok && err != nil {
+				field = zap.String(field.Key, c.replacer.Replace(err.Error()))
+			}
+		case zapcore.StringerType:
+			if s, ok := field.Interface.(fmt.Stringer); ok && s != nil {
+				field = zap.String(field.Key, c.replacer.Replace(s.String()))
+			}
+		case zapcore.ByteStringType:
+			if b, ok := field.Interface.([]byte); ok {
+				field = zap.ByteString(field.Key, []byte(c.replacer.Replace(string(b))))
+			}
+		case zapcore.BinaryType:
+			if b, ok := field.Interface.([]byte); ok {
+				field = zap.Binary(field.Key, []byte(c.replacer.Replace(string(b))))
+			}
+		case zapcore.ReflectType, zapcore.ArrayMarshalerType, zapcore.ObjectMarshalerType:
+			field = c.redactEncodedField(field)
+		}
+		redacted[i] = field
+	}
+	return redacted
+}
+
+// redactEncodedField redacts a field whose value is encoded by zap, e.g.
+// zap.Any, zap.Reflect or zap.Strings. The field is left as is unless its
+// value contains a secret, in which case it is replaced with the redacted
+// JSON value.
+func (c *redactingCore) redactEncodedField(field zapcore.Field) zapcore.Field {
+	// the field is encoded once to look for the secrets, which are rarely
+	// there, before paying for decoding it
+	if !c.hasEncodedSecret(field) {
+		return field
+	}
+
+	enc := zapcore.NewMapObjectEncoder()
+	field.AddTo(enc)
+
+	// the value is decoded from JSON to reach the strings of structs and
+	// reflected maps
+	var value interface{}
+	data, err := json.Marshal(enc.Fields[field.Key])
+	if err == nil {
+		err = json.Unmarshal(data, &value)
+	}
+	if err != nil {
+		// the value can't be checked, so it must not be logged
+		return zap.String(field.Key, redactedSecret)
+	}
+
+	redacted, changed := c.redactValue(value)
+	if !changed {
+		return field
+	}
+	return zap.Any(field.Key, redacted)
+}
+
+// hasEncodedSecret checks whether the JSON encoding of field contains one
+// of the secrets. It is true if the field can't be encoded.
+func (c *redactingCore) hasEncodedSecret(field zapcore.Field) bool {
+	buf, err := c.encoder.EncodeEntry(zapcore.Entry{}, []zapcore.Field{field})
+	if err != nil {
+		return true
+	}
+	defer buf.Free()
+
+	for _, secret := range c.encodedSecrets {
+		if bytes.Contains(buf.Bytes(), secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactValue removes the secrets from the keys and strings of the given
+// JSON value. changed is false if there were no secrets.
+func (c *redactingCore) redactValue(value interface{}) (redacted interface{}, changed bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			redactedKey := c.replacer.Replace(key)
+			redactedVal, valChanged := c.redactValue(val)
+			m[redactedKey] = redactedVal
+			changed = changed || valChanged || redactedKey != key
+		}
+		return m, changed
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, val := range v {
+			var valChanged bool
+			l[i], valChanged = c.redactValue(val)
+			changed = changed || valChanged
+		}
+		return l, changed
+	case string:
+		redactedString := c.replacer.Replace(v)
+		return redactedString, redactedString != v
+	}
+	return value, false
+}
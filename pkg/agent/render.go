@@ -0,0 +1,101 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"go.opentelemetry.io/collector/confmap"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// RenderConfig renders the otel config of the given type (docker, nodocker)
+// exactly like the agent does before writing it to the otel config file,
+// but neither writes the file nor touches the collector. The config is read
+// from apiResponseFile, a saved response of the get configuration api, or
+// fetched from the config source of the agent if apiResponseFile is empty.
+// If configType is empty, it is detected like on a running agent.
+func (c *HostAgent) RenderConfig(ctx context.Context, apiResponseFile string,
+	configType string) ([]byte, error) {
+	if configType == "" {
+		configType = c.getConfigType()
+	}
+
+	var src *SourceConfig
+	if apiResponseFile != "" {
+		data, err := os.ReadFile(apiResponseFile)
+		if err != nil {
+			return nil, err
+		}
+
+		var apiResponse apiResponseForYAML
+		if err := json.Unmarshal(data, &apiResponse); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal api response %s: %w", apiResponseFile, err)
+		}
+
+		src, err = apiResponse.sourceConfig(configType, "")
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		src, err = c.configSource.FetchConfig(ctx, ConfigRequest{Type: configType})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data, _, err := c.renderConfig(src.Config, src.Integrations)
+	return data, err
+}
+
+// ExpandConfigEnv returns the given otel config YAML with the ${env:...}
+// placeholders replaced by their values, as the collector would see it. The
+// secrets of the agent are redacted.
+func (c *HostAgent) ExpandConfigEnv(data []byte) ([]byte, error) {
+	resolver, err := confmap.NewResolver(c.getConfigProviderSettings("yaml:" + string(data)).ResolverSettings)
+	if err != nil {
+		return nil, err
+	}
+
+	conf, err := resolver.Resolve(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand otel config: %w", err)
+	}
+
+	expanded, err := yaml.Marshal(redactConfig(conf.ToStringMap(), c.Secrets()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal expanded otel config: %w", err)
+	}
+
+	return expanded, nil
+}
+
+// DiffRunningConfig returns the unified diff from the otel config file to
+// the given otel config. The diff is empty if both are the same.
+func (c *HostAgent) DiffRunningConfig(data []byte) (string, error) {
+	running, err := os.ReadFile(c.OtelConfigFile)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        diffLines(running),
+		B:        diffLines(data),
+		FromFile: c.OtelConfigFile,
+		ToFile:   "rendered",
+		Context:  3,
+	})
+}
+
+// diffLines splits data into lines which end with a newline, as expected
+// by difflib. difflib.SplitLines adds the newline to the last line itself.
+func diffLines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	return difflib.SplitLines(strings.TrimSuffix(string(data), "\n"))
+}
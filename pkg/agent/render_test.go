@@ -0,0 +1,135 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+const renderAPIResponse = `{
+	"status": true,
+	"config": {
+		"nodocker": {
+			"receivers": {
+				"otlp": {"protocols": {"grpc": {"endpoint": "0.0.0.0:4317"}}},
+				"filelog": {"include": ["/var/log/*.log"]}
+			},
+			"exporters": {
+				"otlp": {"endpoint": "${env:MW_TARGET}", "headers": {"authorization": "${env:MW_API_KEY}"}}
+			},
+			"service": {
+				"pipelines": {
+					"metrics": {"receivers": ["otlp"], "exporters": ["otlp"]},
+					"logs": {"receivers": ["filelog"], "exporters": ["otlp"]}
+				}
+			}
+		},
+		"docker": {
+			"receivers": {"docker_stats": {}},
+			"service": {"pipelines": {}}
+		}
+	}
+}`
+
+func newRenderAgent(t *testing.T, cfg HostConfig) *HostAgent {
+	cfg.OtelConfigFile = filepath.Join(t.TempDir(), "otel-config.yaml")
+	cfg.AgentFeatures.MetricCollection = true
+	agent, err := NewHostAgent(cfg, zapcore.NewNopCore())
+	require.NoError(t, err)
+	return agent
+}
+
+func TestRenderConfig(t *testing.T) {
+	responseFile := filepath.Join(t.TempDir(), "response.json")
+	require.NoError(t, os.WriteFile(responseFile, []byte(renderAPIResponse), 0644))
+
+	agent := newRenderAgent(t, HostConfig{})
+
+	data, err := agent.RenderConfig(context.Background(), responseFile, "nodocker")
+	require.NoError(t, err)
+	config, err := parseConfig(data)
+	require.NoError(t, err)
+
+	// log collection is disabled, so the logs pipeline is removed
+	pipelines := config[Service].(map[string]interface{})[Pipelines].(map[string]interface{})
+	assert.Contains(t, pipelines, "metrics")
+	assert.NotContains(t, pipelines, "logs")
+	assert.NotContains(t, config[Receivers], "filelog")
+
+	data, err = agent.RenderConfig(context.Background(), responseFile, "docker")
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "docker_stats")
+
+	// the config file is neither written nor compared
+	_, err = os.Stat(agent.OtelConfigFile)
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = agent.RenderConfig(context.Background(), filepath.Join(t.TempDir(), "missing.json"), "nodocker")
+	assert.Error(t, err)
+}
+
+func TestRenderConfigFetch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.Header.Get("If-None-Match"))
+		_, _ = w.Write([]byte(renderAPIResponse))
+	}))
+	defer server.Close()
+
+	agent := newRenderAgent(t, HostConfig{
+		BaseConfig: BaseConfig{
+			APIKey:               "secretkey",
+			APIURLForConfigCheck: server.URL,
+		},
+	})
+	agent.setConfigETag("nodocker", "etag")
+
+	data, err := agent.RenderConfig(context.Background(), "", "nodocker")
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "${env:MW_TARGET}")
+}
+
+func TestExpandConfigEnv(t *testing.T) {
+	t.Setenv("MW_TARGET", "https://example.com:443")
+	t.Setenv("MW_API_KEY", "secretkey")
+
+	agent := newRenderAgent(t, HostConfig{
+		BaseConfig: BaseConfig{
+			APIKey: "secretkey",
+		},
+	})
+
+	data, err := agent.ExpandConfigEnv([]byte(`
+exporters:
+  otlp:
+    endpoint: ${env:MW_TARGET}
+    headers:
+      x-key: ${env:MW_API_KEY}
+`))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "endpoint: https://example.com:443")
+	assert.NotContains(t, string(data), "secretkey")
+}
+
+func TestDiffRunningConfig(t *testing.T) {
+	agent := newRenderAgent(t, HostConfig{})
+
+	diff, err := agent.DiffRunningConfig([]byte("a: 1\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "--- "+agent.OtelConfigFile+"\n+++ rendered\n@@ -0,0 +1 @@\n+a: 1\n", diff)
+
+	require.NoError(t, os.WriteFile(agent.OtelConfigFile, []byte("a: 1\nb: 2\n"), 0644))
+	diff, err = agent.DiffRunningConfig([]byte("a: 1\nb: 2\n"))
+	require.NoError(t, err)
+	assert.Empty(t, diff)
+
+	diff, err = agent.DiffRunningConfig([]byte("a: 1\nb: 3\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "--- "+agent.OtelConfigFile+"\n+++ rendered\n@@ -1,2 +1,2 @@\n a: 1\n-b: 2\n+b: 3\n", diff)
+}
@@ -0,0 +1,112 @@
+package agent
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrInvalidPublicKey = errors.New("invalid ed25519 public key")
+	ErrInvalidSignature = errors.New("signature verification failed")
+)
+
+// parseEd25519PublicKey parses an ed25519 public key given either as a PEM
+// encoded PKIX public key or as the base64 encoding of the raw 32 byte key.
+func parseEd25519PublicKey(key string) (ed25519.PublicKey, error) {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return nil, fmt.Errorf("%w: key is empty", ErrInvalidPublicKey)
+	}
+
+	if strings.HasPrefix(key, "-----BEGIN") {
+		block, _ := pem.Decode([]byte(key))
+		if block == nil {
+			return nil, fmt.Errorf("%w: failed to decode PEM block", ErrInvalidPublicKey)
+		}
+
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPublicKey, err)
+		}
+
+		edPub, ok := pub.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("%w: key type is %T", ErrInvalidPublicKey, pub)
+		}
+		return edPub, nil
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPublicKey, err)
+	}
+
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: key length is %d", ErrInvalidPublicKey, len(raw))
+	}
+
+	return ed25519.PublicKey(raw), nil
+}
+
+// verifySignature checks the base64 encoded detached ed25519 signature
+// of data.
+func verifySignature(pub ed25519.PublicKey, data []byte, signature string) error {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(signature))
+	if err != nil {
+		return fmt.Errorf("%w: failed to decode signature: %v", ErrInvalidSignature, err)
+	}
+
+	if !ed25519.Verify(pub, data, sig) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// configSignatureHeader carries the base64 ed25519 signature of the response
+// body of the get configuration api.
+const configSignatureHeader = "X-Config-Signature"
+
+// signatureFileSuffix is appended to the name of a config file to get the
+// name of the file with its detached signature.
+const signatureFileSuffix = ".sig"
+
+// configPublicKey returns the pinned public key which verifies the otel
+// configs, or nil if config signatures are not required.
+func (c BaseConfig) configPublicKey() (ed25519.PublicKey, error) {
+	if c.ConfigPublicKey == "" {
+		return nil, nil
+	}
+	return parseEd25519PublicKey(c.ConfigPublicKey)
+}
+
+// verifySourceConfig checks the detached signature of the config fetched
+// from the config source if a config public key is pinned. Unsigned and
+// mis-signed configs are rejected with ErrInvalidConfig.
+func (c BaseConfig) verifySourceConfig(src *SourceConfig) error {
+	pub, err := c.configPublicKey()
+	if err != nil || pub == nil {
+		return err
+	}
+
+	if src.Signature == "" {
+		return fmt.Errorf("%w: config is not signed", ErrInvalidConfig)
+	}
+
+	if err := verifySignature(pub, src.Payload, src.Signature); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+
+	// the integration configs are merged into the signed receivers, so
+	// they could change them without breaking the signature
+	if src.UnsignedIntegrations && len(src.Integrations) > 0 {
+		return fmt.Errorf("%w: integration configs are not covered by the signature", ErrInvalidConfig)
+	}
+
+	return nil
+}
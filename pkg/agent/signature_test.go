@@ -0,0 +1,143 @@
+package agent
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestVerifySourceConfig(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	payload := []byte(sourceTestConfig)
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, payload))
+
+	// without a pinned key, any config is accepted
+	assert.NoError(t, BaseConfig{}.verifySourceConfig(&SourceConfig{Payload: payload}))
+
+	cfg := BaseConfig{ConfigPublicKey: base64.StdEncoding.EncodeToString(pub)}
+	assert.NoError(t, cfg.verifySourceConfig(&SourceConfig{Payload: payload, Signature: signature}))
+
+	err = cfg.verifySourceConfig(&SourceConfig{Payload: payload})
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.Contains(t, err.Error(), "not signed")
+
+	tampered := []byte(strings.Replace(sourceTestConfig, "debug", "file", 1))
+	assert.ErrorIs(t, cfg.verifySourceConfig(&SourceConfig{Payload: tampered, Signature: signature}),
+		ErrInvalidConfig)
+
+	assert.ErrorIs(t, BaseConfig{ConfigPublicKey: "invalid"}.verifySourceConfig(&SourceConfig{}),
+		ErrInvalidPublicKey)
+}
+
+func TestUpdateConfigFileSignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	config, err := parseConfig([]byte(sourceTestConfig))
+	require.NoError(t, err)
+	body, err := json.Marshal(map[string]interface{}{
+		"status": true,
+		"config": map[string]interface{}{
+			"nodocker": config,
+		},
+	})
+	require.NoError(t, err)
+
+	signature := ""
+	tracked := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, apiAgentTrack) {
+			tracked++
+			return
+		}
+
+		if signature != "" {
+			w.Header().Set(configSignatureHeader, signature)
+		}
+		_, _ = w.Write(body)
+	}))
+	defer mockServer.Close()
+
+	otelConfigFile := filepath.Join(t.TempDir(), "otel-config.yaml")
+	agent, err := NewHostAgent(HostConfig{
+		BaseConfig: BaseConfig{
+			APIKey:               "testAPIKey",
+			APIURLForConfigCheck: mockServer.URL,
+			OtelConfigFile:       otelConfigFile,
+			ConfigPublicKey:      base64.StdEncoding.EncodeToString(pub),
+			AgentFeatures: AgentFeatures{
+				MetricCollection: true,
+				LogCollection:    true,
+			},
+		},
+	}, zapcore.NewNopCore())
+	require.NoError(t, err)
+	closeAgent(t, agent)
+	agent.backoff = backoff{}
+
+	// unsigned
+	assert.ErrorIs(t, agent.updateConfigFile(context.Background(), "nodocker"), ErrInvalidConfig)
+	require.NoError(t, agent.FlushTrackingEvents(context.Background()))
+	assert.Equal(t, 1, tracked)
+	assert.NoFileExists(t, otelConfigFile)
+
+	// signed by another key
+	_, otherPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signature = base64.StdEncoding.EncodeToString(ed25519.Sign(otherPriv, body))
+	assert.ErrorIs(t, agent.updateConfigFile(context.Background(), "nodocker"), ErrInvalidConfig)
+	require.NoError(t, agent.FlushTrackingEvents(context.Background()))
+	assert.Equal(t, 2, tracked)
+	assert.NoFileExists(t, otelConfigFile)
+
+	signature = base64.StdEncoding.EncodeToString(ed25519.Sign(priv, body))
+	assert.NoError(t, agent.updateConfigFile(context.Background(), "nodocker"))
+	assert.FileExists(t, otelConfigFile)
+}
+
+func TestDirConfigSourceSignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "nodocker.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(sourceTestConfig), 0o644))
+
+	cfg := BaseConfig{ConfigPublicKey: base64.StdEncoding.EncodeToString(pub)}
+	src, err := NewDirConfigSource(dir).FetchConfig(context.Background(), ConfigRequest{Type: "nodocker"})
+	require.NoError(t, err)
+	assert.ErrorIs(t, cfg.verifySourceConfig(src), ErrInvalidConfig)
+
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte(sourceTestConfig)))
+	require.NoError(t, os.WriteFile(path+signatureFileSuffix, []byte(signature+"\n"), 0o644))
+	src, err = NewDirConfigSource(dir).FetchConfig(context.Background(), ConfigRequest{Type: "nodocker"})
+	require.NoError(t, err)
+	assert.NoError(t, cfg.verifySourceConfig(src))
+
+	// the integration configs are not signed
+	require.NoError(t, os.Mkdir(filepath.Join(dir, sourceIntegrationsDirName), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, sourceIntegrationsDirName, "redis.yaml"),
+		[]byte("redis:\n  endpoint: localhost:6379\n"), 0o644))
+	src, err = NewDirConfigSource(dir).FetchConfig(context.Background(), ConfigRequest{Type: "nodocker"})
+	require.NoError(t, err)
+	err = cfg.verifySourceConfig(src)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.Contains(t, err.Error(), "integration configs")
+
+	// they are fine without a pinned key
+	assert.NoError(t, BaseConfig{}.verifySourceConfig(src))
+}
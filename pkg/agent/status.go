@@ -0,0 +1,160 @@
+package agent
+
+import (
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/collector/otelcol"
+)
+
+// maxDecisions is the number of restart decisions kept for the status
+// server
+const maxDecisions = 50
+
+// Decision actions taken by the agent on the result of a config check
+const (
+	DecisionStart   = "start"
+	DecisionRestart = "restart"
+	DecisionStop    = "stop"
+	DecisionKeep    = "keep"
+)
+
+// Decision records what the agent did with the collector and why.
+type Decision struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// AgentStatus is the state of the agent served by the status server.
+type AgentStatus struct {
+	Version          string    `json:"version"`
+	InfraPlatform    string    `json:"infra_platform"`
+	CollectorRunning bool      `json:"collector_running"`
+	CollectorState   string    `json:"collector_state"`
+	ConfigFile       string    `json:"config_file"`
+	ConfigLoadedAt   time.Time `json:"config_loaded_at,omitempty"`
+	LastConfigFetch  time.Time `json:"last_config_fetch,omitempty"`
+	LastFetchError   string    `json:"last_fetch_error,omitempty"`
+	LastError        string    `json:"last_error,omitempty"`
+	Integrations     []string  `json:"integrations"`
+	OfflineBundle    string    `json:"offline_bundle,omitempty"`
+	// LastConfigChange is nil if the agent has not changed the otel config
+	LastConfigChange *ConfigChange `json:"last_config_change,omitempty"`
+	// PolicyViolations are the local policy rules fired by the last
+	// rendered otel config
+	PolicyViolations []PolicyViolation `json:"policy_violations,omitempty"`
+}
+
+// agentState tracks the state of HostAgent which is not part of its config.
+type agentState struct {
+	mu              sync.Mutex
+	lastConfigFetch time.Time
+	lastFetchError  string
+	integrations    []string
+	decisions       []Decision
+	lastChange      *ConfigChange
+	violations      []PolicyViolation
+}
+
+func (s *agentState) recordConfigFetch(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastConfigFetch = time.Now()
+	s.lastFetchError = ""
+	if err != nil {
+		s.lastFetchError = err.Error()
+	}
+}
+
+func (s *agentState) setIntegrations(integrations []IntegrationType) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.integrations = make([]string, 0, len(integrations))
+	for _, integration := range integrations {
+		s.integrations = append(s.integrations, integration.String())
+	}
+}
+
+func (s *agentState) recordConfigChange(change ConfigChange) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastChange = &change
+}
+
+func (s *agentState) setPolicyViolations(violations []PolicyViolation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.violations = violations
+}
+
+func (s *agentState) recordDecision(d Decision) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.decisions = append(s.decisions, d)
+	if len(s.decisions) > maxDecisions {
+		s.decisions = s.decisions[len(s.decisions)-maxDecisions:]
+	}
+}
+
+// RecordDecision records the action taken on the collector because of the
+// given error from ListenForConfigChanges. err is nil if the collector was
+// started without an error.
+func (c *HostAgent) RecordDecision(action string, err error) {
+	d := Decision{
+		Time:   time.Now(),
+		Action: action,
+	}
+	if err != nil {
+		d.Reason = err.Error()
+	}
+	c.state.recordDecision(d)
+}
+
+// Decisions returns the latest restart decisions, oldest first.
+func (c *HostAgent) Decisions() []Decision {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	return append([]Decision(nil), c.state.decisions...)
+}
+
+// Status returns the current state of the agent.
+func (c *HostAgent) Status() AgentStatus {
+	status := AgentStatus{
+		Version:       c.Version,
+		InfraPlatform: c.InfraPlatform.String(),
+		ConfigFile:    c.OtelConfigFile,
+		OfflineBundle: c.OfflineBundle,
+	}
+
+	collectorState := otelcol.StateClosed
+	if collector := c.runningCollector(); collector != nil {
+		collectorState = collector.GetState()
+	}
+	status.CollectorState = collectorState.String()
+	status.CollectorRunning = collectorState == otelcol.StateRunning
+
+	c.configMu.Lock()
+	status.ConfigLoadedAt = c.configLoadedAt
+	c.configMu.Unlock()
+
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	status.LastConfigFetch = c.state.lastConfigFetch
+	status.LastFetchError = c.state.lastFetchError
+	status.Integrations = append([]string{}, c.state.integrations...)
+	status.LastConfigChange = c.state.lastChange
+	status.PolicyViolations = append([]PolicyViolation(nil), c.state.violations...)
+	if len(c.state.decisions) > 0 {
+		status.LastError = c.state.decisions[len(c.state.decisions)-1].Reason
+	}
+
+	return status
+}
@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestHostAgentDecisions(t *testing.T) {
+	agent, err := NewHostAgent(HostConfig{}, zapcore.NewNopCore())
+	assert.NoError(t, err)
+
+	assert.Empty(t, agent.Decisions())
+
+	agent.RecordDecision(DecisionStart, nil)
+	agent.RecordDecision(DecisionKeep, ErrTransient)
+
+	decisions := agent.Decisions()
+	assert.Len(t, decisions, 2)
+	assert.Equal(t, DecisionStart, decisions[0].Action)
+	assert.Empty(t, decisions[0].Reason)
+	assert.Equal(t, DecisionKeep, decisions[1].Action)
+	assert.Equal(t, ErrTransient.Error(), decisions[1].Reason)
+
+	// only the latest decisions are kept
+	for i := 0; i < maxDecisions; i++ {
+		agent.RecordDecision(DecisionRestart, fmt.Errorf("restart %d", i))
+	}
+
+	decisions = agent.Decisions()
+	assert.Len(t, decisions, maxDecisions)
+	assert.Equal(t, "restart 0", decisions[0].Reason)
+	assert.Equal(t, fmt.Sprintf("restart %d", maxDecisions-1), decisions[maxDecisions-1].Reason)
+}
+
+func TestHostAgentStatus(t *testing.T) {
+	agent, err := NewHostAgent(HostConfig{
+		BaseConfig: BaseConfig{
+			OtelConfigFile: "otel-config.yaml",
+		},
+	}, zapcore.NewNopCore(),
+		WithHostAgentVersion("1.2.3"),
+		WithHostAgentInfraPlatform(InfraPlatformECSFargate))
+	assert.NoError(t, err)
+
+	status := agent.Status()
+	assert.Equal(t, "1.2.3", status.Version)
+	assert.Equal(t, InfraPlatformECSFargate.String(), status.InfraPlatform)
+	assert.Equal(t, "otel-config.yaml", status.ConfigFile)
+	assert.False(t, status.CollectorRunning)
+	assert.True(t, status.LastConfigFetch.IsZero())
+	assert.Empty(t, status.Integrations)
+
+	agent.state.recordConfigFetch(errors.New("fetch failed"))
+	agent.state.setIntegrations([]IntegrationType{PostgreSQL, Redis})
+	agent.RecordDecision(DecisionStop, ErrAuthRevoked)
+
+	status = agent.Status()
+	assert.False(t, status.LastConfigFetch.IsZero())
+	assert.Equal(t, "fetch failed", status.LastFetchError)
+	assert.Equal(t, []string{"postgresql", "redis"}, status.Integrations)
+	assert.Equal(t, ErrAuthRevoked.Error(), status.LastError)
+
+	agent.state.recordConfigFetch(nil)
+	assert.Empty(t, agent.Status().LastFetchError)
+}
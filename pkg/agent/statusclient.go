@@ -0,0 +1,122 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// StatusClient queries the status server of a running agent.
+type StatusClient struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewStatusClient returns a client for the status server listening on the
+// given address, which is either a host:port or unix:// followed by the
+// path of a unix socket.
+func NewStatusClient(address string) *StatusClient {
+	transport := &http.Transport{}
+	baseURL := "http://" + address
+	if strings.HasPrefix(address, unixSocketPrefix) {
+		socketPath := strings.TrimPrefix(address, unixSocketPrefix)
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+		}
+		baseURL = "http://localhost"
+	}
+
+	return &StatusClient{
+		baseURL: baseURL,
+		httpClient: &http.Client{
+			Transport: transport,
+			Timeout:   5 * time.Second,
+		},
+	}
+}
+
+func (s *StatusClient) get(ctx context.Context, path string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach agent status server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("agent status server returned status code %d for %s",
+			resp.StatusCode, path)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Status returns the status of the agent.
+func (s *StatusClient) Status(ctx context.Context) (AgentStatus, error) {
+	var status AgentStatus
+	err := s.get(ctx, StatusPathStatus, &status)
+	return status, err
+}
+
+// Decisions returns the latest restart decisions of the agent.
+func (s *StatusClient) Decisions(ctx context.Context) ([]Decision, error) {
+	var decisions []Decision
+	err := s.get(ctx, StatusPathDecisions, &decisions)
+	return decisions, err
+}
+
+// Config returns the effective config of the agent.
+func (s *StatusClient) Config(ctx context.Context) (EffectiveConfig, error) {
+	var config EffectiveConfig
+	err := s.get(ctx, StatusPathConfig, &config)
+	return config, err
+}
+
+// PipelineSummary lists the components of an otel pipeline.
+type PipelineSummary struct {
+	Name       string
+	Receivers  []string
+	Processors []string
+	Exporters  []string
+}
+
+// SummarizePipelines returns the summary of the pipelines in the given otel
+// config, sorted by name.
+func SummarizePipelines(otelConfig map[string]interface{}) []PipelineSummary {
+	service, _ := otelConfig[Service].(map[string]interface{})
+	pipelines, _ := service[Pipelines].(map[string]interface{})
+
+	components := func(pipeline map[string]interface{}, key string) []string {
+		values, _ := pipeline[key].([]interface{})
+		names := make([]string, 0, len(values))
+		for _, value := range values {
+			names = append(names, fmt.Sprint(value))
+		}
+		return names
+	}
+
+	summaries := make([]PipelineSummary, 0, len(pipelines))
+	for name, value := range pipelines {
+		pipeline, _ := value.(map[string]interface{})
+		summaries = append(summaries, PipelineSummary{
+			Name:       name,
+			Receivers:  components(pipeline, Receivers),
+			Processors: components(pipeline, "processors"),
+			Exporters:  components(pipeline, "exporters"),
+		})
+	}
+
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Name < summaries[j].Name
+	})
+	return summaries
+}
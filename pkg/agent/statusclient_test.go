@@ -0,0 +1,96 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestSummarizePipelines(t *testing.T) {
+	config, err := parseConfig([]byte(`
+service:
+  pipelines:
+    traces:
+      receivers: [otlp]
+      exporters: [otlp/2]
+    metrics:
+      receivers: [hostmetrics, otlp]
+      processors: [batch]
+      exporters: [otlp/2]
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, []PipelineSummary{
+		{
+			Name:       "metrics",
+			Receivers:  []string{"hostmetrics", "otlp"},
+			Processors: []string{"batch"},
+			Exporters:  []string{"otlp/2"},
+		},
+		{
+			Name:       "traces",
+			Receivers:  []string{"otlp"},
+			Processors: []string{},
+			Exporters:  []string{"otlp/2"},
+		},
+	}, SummarizePipelines(config))
+
+	assert.Empty(t, SummarizePipelines(map[string]interface{}{}))
+}
+
+func TestStatusClient(t *testing.T) {
+	otelConfigFile := filepath.Join(t.TempDir(), "otel-config.yaml")
+	require.NoError(t, os.WriteFile(otelConfigFile, []byte(`
+service:
+  pipelines:
+    logs:
+      receivers: [filelog]
+`), 0644))
+
+	agent, err := NewHostAgent(HostConfig{
+		BaseConfig: BaseConfig{
+			OtelConfigFile: otelConfigFile,
+		},
+	}, zapcore.NewNopCore(), WithHostAgentVersion("1.2.3"))
+	require.NoError(t, err)
+	agent.RecordDecision(DecisionStart, nil)
+
+	for _, address := range []string{
+		"127.0.0.1:0",
+		unixSocketPrefix + filepath.Join(t.TempDir(), "status.sock"),
+	} {
+		s, err := NewStatusServer(agent, address)
+		require.NoError(t, err)
+		go func() {
+			_ = s.Serve()
+		}()
+
+		if s.Addr().Network() == "tcp" {
+			address = s.Addr().String()
+		}
+		client := NewStatusClient(address)
+
+		status, err := client.Status(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "1.2.3", status.Version)
+
+		decisions, err := client.Decisions(context.Background())
+		assert.NoError(t, err)
+		assert.Len(t, decisions, 1)
+
+		config, err := client.Config(context.Background())
+		assert.NoError(t, err)
+		assert.Empty(t, config.Error)
+		assert.Len(t, SummarizePipelines(config.OtelConfig), 1)
+
+		assert.NoError(t, s.Close())
+	}
+
+	_, err = NewStatusClient("127.0.0.1:1").Status(context.Background())
+	assert.Error(t, err)
+}
@@ -0,0 +1,182 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const unixSocketPrefix = "unix://"
+
+// DefaultStatusAddress is the default address of the status server
+const DefaultStatusAddress = "127.0.0.1:9321"
+
+var (
+	ErrStatusAddressNotLocal = errors.New("status server address is not a loopback address")
+	// ErrStatusAddressNotSocket is returned when the path of the unix
+	// socket is taken by a file which isn't a socket
+	ErrStatusAddressNotSocket = errors.New("status server address is not a unix socket")
+)
+
+// Status server endpoints
+const (
+	StatusPathStatus    = "/v1/status"
+	StatusPathConfig    = "/v1/config"
+	StatusPathDecisions = "/v1/decisions"
+)
+
+// StatusServer serves the state of a HostAgent over HTTP on a loopback
+// address or a unix socket. It is meant for local troubleshooting only.
+type StatusServer struct {
+	agent    *HostAgent
+	listener net.Listener
+	server   *http.Server
+}
+
+// statusListen listens on the given status server address, which is either
+// a loopback host:port or unix:// followed by the path of a unix socket.
+func statusListen(address string) (net.Listener, error) {
+	if strings.HasPrefix(address, unixSocketPrefix) {
+		socketPath := strings.TrimPrefix(address, unixSocketPrefix)
+		// remove the socket left behind by a previous run, but never a
+		// file which isn't a socket
+		if _, err := os.Lstat(socketPath); err == nil {
+			if !isSocket(socketPath) {
+				return nil, fmt.Errorf("%w: %s", ErrStatusAddressNotSocket, socketPath)
+			}
+			if err := os.Remove(socketPath); err != nil {
+				return nil, fmt.Errorf("failed to remove stale socket %s: %w", socketPath, err)
+			}
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		listener, err := net.Listen("unix", socketPath)
+		if err != nil {
+			return nil, err
+		}
+
+		// the effective config is served, so only the user of the agent
+		// may connect
+		if err := os.Chmod(socketPath, 0600); err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("failed to restrict access to socket %s: %w", socketPath, err)
+		}
+		return listener, nil
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ip := range ips {
+		if !ip.IsLoopback() {
+			return nil, fmt.Errorf("%w: %s", ErrStatusAddressNotLocal, address)
+		}
+	}
+
+	return net.Listen("tcp", address)
+}
+
+// NewStatusServer returns a status server for the given agent listening on
+// the given address.
+func NewStatusServer(agent *HostAgent, address string) (*StatusServer, error) {
+	listener, err := statusListen(address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on status address %s: %w", address, err)
+	}
+
+	s := &StatusServer{
+		agent:    agent,
+		listener: listener,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(StatusPathStatus, s.handleStatus)
+	mux.HandleFunc(StatusPathConfig, s.handleConfig)
+	mux.HandleFunc(StatusPathDecisions, s.handleDecisions)
+
+	s.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	return s, nil
+}
+
+// Serve serves the status endpoints until the server is closed.
+func (s *StatusServer) Serve() error {
+	s.agent.logger.Info("starting status server",
+		zap.String("address", s.listener.Addr().String()))
+
+	err := s.server.Serve(s.listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Close stops the status server.
+func (s *StatusServer) Close() error {
+	return s.server.Close()
+}
+
+// Addr returns the address the status server is listening on.
+func (s *StatusServer) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+func (s *StatusServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.agent.Status())
+}
+
+func (s *StatusServer) handleDecisions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.agent.Decisions())
+}
+
+// EffectiveConfig is the config of the agent served by the status server.
+type EffectiveConfig struct {
+	AgentConfig string                 `json:"agent_config"`
+	OtelConfig  map[string]interface{} `json:"otel_config"`
+	// Error is set if the otel config file could not be read
+	Error string `json:"error,omitempty"`
+}
+
+// handleConfig serves the agent config and the otel config which the
+// collector runs with, both without secrets.
+func (s *StatusServer) handleConfig(w http.ResponseWriter, r *http.Request) {
+	response := EffectiveConfig{
+		AgentConfig: s.agent.HostConfig.String(),
+	}
+
+	otelConfig, err := readConfigFile(s.agent.OtelConfigFile)
+	if err != nil {
+		response.Error = err.Error()
+	} else {
+		response.OtelConfig = redactConfig(otelConfig, s.agent.Secrets())
+	}
+
+	writeJSON(w, response)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,111 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestNewStatusServerAddress(t *testing.T) {
+	agent, err := NewHostAgent(HostConfig{}, zapcore.NewNopCore())
+	require.NoError(t, err)
+
+	_, err = NewStatusServer(agent, "0.0.0.0:0")
+	assert.ErrorIs(t, err, ErrStatusAddressNotLocal)
+
+	_, err = NewStatusServer(agent, "invalid")
+	assert.Error(t, err)
+
+	s, err := NewStatusServer(agent, "127.0.0.1:0")
+	require.NoError(t, err)
+	assert.NoError(t, s.Close())
+
+	// a file which isn't a socket is never removed
+	path := filepath.Join(t.TempDir(), "status.sock")
+	require.NoError(t, os.WriteFile(path, []byte("data"), 0644))
+	_, err = NewStatusServer(agent, unixSocketPrefix+path)
+	assert.ErrorIs(t, err, ErrStatusAddressNotSocket)
+	assert.FileExists(t, path)
+}
+
+func TestStatusServer(t *testing.T) {
+	otelConfigFile := filepath.Join(t.TempDir(), "otel-config.yaml")
+	require.NoError(t, os.WriteFile(otelConfigFile, []byte(`
+exporters:
+  otlp:
+    endpoint: https://example.com
+    headers:
+      authorization: secretkey
+`), 0644))
+
+	agent, err := NewHostAgent(HostConfig{
+		BaseConfig: BaseConfig{
+			APIKey:         "secretkey",
+			OtelConfigFile: otelConfigFile,
+		},
+	}, zapcore.NewNopCore())
+	require.NoError(t, err)
+
+	agent.RecordDecision(DecisionStart, nil)
+
+	socketPath := filepath.Join(t.TempDir(), "status.sock")
+	// a stale socket from a previous run is replaced
+	stale, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.NoError(t, stale.Close())
+
+	s, err := NewStatusServer(agent, unixSocketPrefix+socketPath)
+	require.NoError(t, err)
+
+	info, err := os.Stat(socketPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	go func() {
+		_ = s.Serve()
+	}()
+	defer s.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+
+	get := func(path string, v interface{}) {
+		resp, err := client.Get("http://localhost" + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+	}
+
+	var status AgentStatus
+	get(StatusPathStatus, &status)
+	assert.Equal(t, otelConfigFile, status.ConfigFile)
+	assert.False(t, status.CollectorRunning)
+
+	var decisions []Decision
+	get(StatusPathDecisions, &decisions)
+	require.Len(t, decisions, 1)
+	assert.Equal(t, DecisionStart, decisions[0].Action)
+
+	var config map[string]interface{}
+	get(StatusPathConfig, &config)
+	assert.NotContains(t, config["agent_config"], "secretkey")
+	otelConfig, err := json.Marshal(config["otel_config"])
+	require.NoError(t, err)
+	assert.NotContains(t, string(otelConfig), "secretkey")
+	assert.Contains(t, string(otelConfig), "https://example.com")
+}
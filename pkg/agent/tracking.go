@@ -0,0 +1,455 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// Lifecycle events of the agent sent to Middleware backend as the status of
+// TrackingPayload
+const (
+	// TrackEventInstalled is sent on the first start of the agent on a host
+	TrackEventInstalled        = "installed"
+	TrackEventCollectorStarted = "collector_started"
+	TrackEventCollectorStopped = "collector_stopped"
+	TrackEventConfigApplied    = "config_applied"
+	// TrackEventConfigRejected keeps the status the backend has always
+	// received for rejected configs
+	TrackEventConfigRejected   = "validate"
+	TrackEventRestartRequested = "restart_requested"
+	// TrackEventCrashRestarted is sent when the collector is restarted with
+	// the last known good config after it failed
+	TrackEventCrashRestarted = "crash_restarted"
+	TrackEventShutdown       = "shutdown"
+)
+
+const (
+	trackingQueueDirName = "tracking-queue"
+	trackingQueueExt     = ".json"
+	// maxQueuedEvents bounds the events kept on disk while the backend is
+	// unavailable. The oldest events are dropped first.
+	maxQueuedEvents = 100
+	// trackingTimeout bounds sending an event along with the queued ones,
+	// so that the lifecycle of the agent is not held up by the backend
+	trackingTimeout = 5 * time.Second
+)
+
+// trackingQueue keeps the tracking events which could not be sent, one
+// file per event, until they are delivered.
+type trackingQueue struct {
+	dir       string
+	maxEvents int
+}
+
+func newTrackingQueue(dir string, maxEvents int) *trackingQueue {
+	return &trackingQueue{
+		dir:       dir,
+		maxEvents: maxEvents,
+	}
+}
+
+// push adds payload to the queue, dropping the oldest events if the queue
+// is full.
+func (q *trackingQueue) push(payload TrackingPayload) error {
+	if err := os.MkdirAll(q.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create tracking queue directory %s: %w", q.dir, err)
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	// the names sort in the order of the events
+	name := fmt.Sprintf("%020d-%s%s", payload.Timestamp.UnixNano(), payload.ID, trackingQueueExt)
+	if err := writeFileAtomic(filepath.Join(q.dir, name), data, 0644); err != nil {
+		return err
+	}
+
+	names, err := q.names()
+	if err != nil {
+		return err
+	}
+	for len(names) > q.maxEvents {
+		if err := q.remove(names[0]); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// names returns the names of the queued events, oldest first.
+func (q *trackingQueue) names() ([]string, error) {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), trackingQueueExt) {
+			continue
+		}
+		names = append(names, entry.Name())
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+func (q *trackingQueue) read(name string) (TrackingPayload, error) {
+	var payload TrackingPayload
+	data, err := os.ReadFile(filepath.Join(q.dir, name))
+	if err != nil {
+		return payload, err
+	}
+
+	err = json.Unmarshal(data, &payload)
+	return payload, err
+}
+
+func (q *trackingQueue) remove(name string) error {
+	err := os.Remove(filepath.Join(q.dir, name))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// newTrackingPayload returns the payload of the given event. configData is
+// the otel config the event is about and previousData the config it
+// replaced, nil if there is none.
+func (c *HostAgent) newTrackingPayload(status string, reason error,
+	configData []byte, previousData []byte) TrackingPayload {
+	payload := TrackingPayload{
+		ID:        uuid.NewString(),
+		Status:    status,
+		Timestamp: time.Now().UTC(),
+		Metadata: TrackingMetadata{
+			HostID:        c.HostID(),
+			AgentID:       c.agentID,
+			Platform:      runtime.GOOS,
+			AgentVersion:  c.Version,
+			InfraPlatform: fmt.Sprint(c.InfraPlatform),
+		},
+	}
+	if reason != nil {
+		payload.Metadata.Reason = reason.Error()
+	}
+	if len(configData) > 0 {
+		payload.ConfigHash = configHash(configData)
+	}
+	if len(previousData) > 0 {
+		payload.PreviousConfigHash = configHash(previousData)
+	}
+	return payload
+}
+
+// trackingEnabled checks whether the lifecycle events are sent to
+// Middleware backend. An agent in offline mode never contacts the backend.
+func (c *HostAgent) trackingEnabled() bool {
+	return c.OfflineBundle == ""
+}
+
+// TrackEvent sends the given lifecycle event of the agent to Middleware
+// backend, along with the hash of the otel config file. If the event can't
+// be sent, it is queued on disk and sent again before the next event.
+func (c *HostAgent) TrackEvent(status string, reason error) error {
+	if !c.trackingEnabled() {
+		return nil
+	}
+
+	// the config file may not exist yet
+	configData, _ := os.ReadFile(c.OtelConfigFile)
+	return c.sendTrackingEvent(context.Background(),
+		c.newTrackingPayload(status, reason, configData, nil))
+}
+
+// TrackEventAsync is like TrackEvent, but it returns without waiting for
+// the event to be sent. Failures are only logged. FlushTrackingEvents and
+// Close wait for the events tracked so far.
+func (c *HostAgent) TrackEventAsync(status string, reason error) {
+	// the config file may not exist yet
+	configData, _ := os.ReadFile(c.OtelConfigFile)
+	c.trackEvent(status, reason, configData, nil)
+}
+
+// UpdateAgentTrackStatus reports to Middleware backend that the otel config
+// was rejected for the given reason.
+func (c *HostAgent) UpdateAgentTrackStatus(reason error) error {
+	return c.TrackEvent(TrackEventConfigRejected, reason)
+}
+
+// trackedEvent is either an event tracked asynchronously or, if flushed is
+// not nil, a marker which is closed once the events before it are handled.
+type trackedEvent struct {
+	payload TrackingPayload
+	flushed chan struct{}
+}
+
+// trackEvent is like TrackEventAsync for the events about the given otel
+// configs. The events are handed to sendTrackedEvents so that the
+// lifecycle of the collector is not held up by the backend.
+func (c *HostAgent) trackEvent(status string, reason error, configData []byte,
+	previousData []byte) {
+	if !c.trackingEnabled() {
+		return
+	}
+
+	c.trackingChMu.Lock()
+	defer c.trackingChMu.Unlock()
+
+	if c.trackingClosed {
+		c.logger.Debug("agent is closed, dropping tracking event", zap.String("event", status))
+		return
+	}
+
+	c.startTrackedEvents()
+	select {
+	case c.trackingCh <- trackedEvent{
+		payload: c.newTrackingPayload(status, reason, configData, previousData),
+	}:
+	default:
+		c.logger.Warn("too many pending agent tracking events, dropping event",
+			zap.String("event", status))
+	}
+}
+
+// startTrackedEvents starts sending the events tracked asynchronously,
+// unless it is already started. The caller must hold trackingChMu.
+func (c *HostAgent) startTrackedEvents() {
+	if c.trackingCh != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.trackingCh = make(chan trackedEvent, maxQueuedEvents)
+	c.trackingDone = make(chan struct{})
+	c.trackingCancel = cancel
+	go c.sendTrackedEvents(ctx, c.trackingCh, c.trackingDone)
+}
+
+// sendTrackedEvents sends the events tracked asynchronously in order, until
+// events is closed. Once ctx is done, the events are queued on disk
+// instead of waiting for the backend.
+func (c *HostAgent) sendTrackedEvents(ctx context.Context, events <-chan trackedEvent,
+	done chan<- struct{}) {
+	defer close(done)
+
+	for event := range events {
+		if event.flushed != nil {
+			close(event.flushed)
+			continue
+		}
+
+		if err := c.sendTrackingEvent(ctx, event.payload); err != nil {
+			c.logger.Warn("failed to send agent tracking event",
+				zap.String("event", event.payload.Status), zap.Error(err))
+		}
+	}
+}
+
+// FlushTrackingEvents waits until the events tracked asynchronously so far
+// are sent, or queued on disk if they can't be sent for now. It returns
+// ctx.Err() if ctx is done first.
+func (c *HostAgent) FlushTrackingEvents(ctx context.Context) error {
+	if !c.trackingEnabled() {
+		return nil
+	}
+
+	flushed := make(chan struct{})
+	if err := c.pushTrackedEvent(ctx, trackedEvent{flushed: flushed}); err != nil {
+		return err
+	}
+
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pushTrackedEvent hands event to sendTrackedEvents, waiting for room in
+// trackingCh until ctx is done. The events tracked before Close are
+// already handled once it returns, so a closed agent has nothing to wait
+// for.
+func (c *HostAgent) pushTrackedEvent(ctx context.Context, event trackedEvent) error {
+	c.trackingChMu.Lock()
+	defer c.trackingChMu.Unlock()
+
+	if c.trackingClosed {
+		if event.flushed != nil {
+			close(event.flushed)
+		}
+		return nil
+	}
+
+	c.startTrackedEvents()
+	select {
+	case c.trackingCh <- event:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops tracking events asynchronously. It waits until the events
+// tracked so far are sent, or queued on disk if they can't be sent for now.
+// If ctx is done first, the pending events are queued on disk without
+// waiting for the backend, and ctx.Err() is returned. The events tracked
+// after Close are dropped.
+func (c *HostAgent) Close(ctx context.Context) error {
+	c.trackingChMu.Lock()
+	if !c.trackingClosed {
+		c.trackingClosed = true
+		if c.trackingCh != nil {
+			close(c.trackingCh)
+		}
+	}
+	done, cancel := c.trackingDone, c.trackingCancel
+	c.trackingChMu.Unlock()
+
+	if done == nil {
+		return nil
+	}
+
+	select {
+	case <-done:
+		cancel()
+		return nil
+	case <-ctx.Done():
+	}
+
+	cancel()
+	<-done
+	return ctx.Err()
+}
+
+// sendTrackingEvent sends the queued events and then payload, so that the
+// backend receives the events in order. payload is queued if it can't be
+// sent for now.
+func (c *HostAgent) sendTrackingEvent(ctx context.Context, payload TrackingPayload) error {
+	c.trackingMu.Lock()
+	defer c.trackingMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, trackingTimeout)
+	defer cancel()
+
+	err := c.flushTrackingQueue(ctx)
+	if err == nil {
+		err = c.postTrackingEvent(ctx, payload)
+	}
+
+	if isRetryableTrackingError(err) && c.trackingQueue != nil {
+		if queueErr := c.trackingQueue.push(payload); queueErr != nil {
+			c.logger.Warn("failed to queue agent tracking event",
+				zap.String("event", payload.Status), zap.Error(queueErr))
+		}
+	}
+	return err
+}
+
+// retryTrackingEvents sends the queued events, if any.
+func (c *HostAgent) retryTrackingEvents() {
+	c.trackingMu.Lock()
+	defer c.trackingMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), trackingTimeout)
+	defer cancel()
+
+	if err := c.flushTrackingQueue(ctx); err != nil {
+		c.logger.Debug("failed to send queued agent tracking events", zap.Error(err))
+	}
+}
+
+// flushTrackingQueue sends the queued events, oldest first. It stops at
+// the first event which can be sent later. The events rejected by the
+// backend are dropped. The caller must hold trackingMu.
+func (c *HostAgent) flushTrackingQueue(ctx context.Context) error {
+	if c.trackingQueue == nil {
+		return nil
+	}
+
+	names, err := c.trackingQueue.names()
+	if err != nil {
+		c.logger.Warn("failed to read agent tracking queue", zap.Error(err))
+		return nil
+	}
+
+	for _, name := range names {
+		payload, err := c.trackingQueue.read(name)
+		if err == nil {
+			err = c.postTrackingEvent(ctx, payload)
+		}
+		if isRetryableTrackingError(err) {
+			return err
+		}
+		if err != nil {
+			c.logger.Warn("dropping queued agent tracking event",
+				zap.String("event", name), zap.Error(err))
+		}
+
+		if err := c.trackingQueue.remove(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isRetryableTrackingError checks whether an event which failed with err
+// can be delivered later, e.g. once the backend is back or accepts the API
+// key again.
+func isRetryableTrackingError(err error) bool {
+	return errors.Is(err, ErrTransient) || errors.Is(err, ErrAuthRevoked)
+}
+
+// postTrackingEvent makes a single attempt to send payload to the agent
+// tracking API. The retries are left to the queue.
+func (c *HostAgent) postTrackingEvent(ctx context.Context, payload TrackingPayload) error {
+	baseURL, err := c.apiURL(apiAgentTrack)
+	if err != nil {
+		return err
+	}
+
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL.String(),
+		bytes.NewReader(payloadBytes))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	c.setAPIKey(req)
+
+	resp, err := c.httpDoFunc(req)
+	if err != nil {
+		return fmt.Errorf("%w: Agent Track API request failed: %v", ErrTransient, c.redactError(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return statusError("Agent Track API", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,265 @@
+package agent
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+// trackingServer records the tracking events it accepts. The events are
+// answered with status until it is set to http.StatusOK.
+type trackingServer struct {
+	status int
+	events []TrackingPayload
+}
+
+func newTrackingServer(t *testing.T) (*trackingServer, *httptest.Server) {
+	s := &trackingServer{status: http.StatusOK}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Path, apiAgentTrack) {
+			http.NotFound(w, r)
+			return
+		}
+
+		if s.status != http.StatusOK {
+			w.WriteHeader(s.status)
+			return
+		}
+
+		var payload TrackingPayload
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		s.events = append(s.events, payload)
+	}))
+	t.Cleanup(server.Close)
+	return s, server
+}
+
+func (s *trackingServer) statuses() []string {
+	statuses := []string{}
+	for _, event := range s.events {
+		statuses = append(statuses, event.Status)
+	}
+	return statuses
+}
+
+func newTrackingAgent(t *testing.T, apiURL string) *HostAgent {
+	agent, err := NewHostAgent(HostConfig{
+		BaseConfig: BaseConfig{
+			APIKey:               "testAPIKey",
+			APIURLForConfigCheck: apiURL,
+			OtelConfigFile:       filepath.Join(t.TempDir(), "otel-config.yaml"),
+		},
+	}, zapcore.NewNopCore(), WithHostAgentVersion("1.0.0"))
+	require.NoError(t, err)
+	closeAgent(t, agent)
+	return agent
+}
+
+func TestTrackEvent(t *testing.T) {
+	tracking, server := newTrackingServer(t)
+	agent := newTrackingAgent(t, server.URL)
+
+	require.NoError(t, os.WriteFile(agent.OtelConfigFile, []byte(sourceTestConfig), 0644))
+	require.NoError(t, agent.TrackEvent(TrackEventInstalled, nil))
+	require.NoError(t, agent.TrackEvent(TrackEventCollectorStopped, errors.New("api key rejected")))
+
+	require.Len(t, tracking.events, 2)
+	event := tracking.events[1]
+	assert.NotEmpty(t, event.ID)
+	assert.NotEqual(t, tracking.events[0].ID, event.ID)
+	assert.Equal(t, TrackEventCollectorStopped, event.Status)
+	assert.WithinDuration(t, time.Now(), event.Timestamp, time.Minute)
+	assert.Equal(t, configHash([]byte(sourceTestConfig)), event.ConfigHash)
+	assert.Empty(t, event.PreviousConfigHash)
+	assert.Equal(t, "api key rejected", event.Metadata.Reason)
+	assert.Equal(t, "1.0.0", event.Metadata.AgentVersion)
+}
+
+func TestTrackEventQueue(t *testing.T) {
+	tracking, server := newTrackingServer(t)
+	agent := newTrackingAgent(t, server.URL)
+
+	// the events are queued while the backend is unavailable
+	tracking.status = http.StatusServiceUnavailable
+	assert.ErrorIs(t, agent.TrackEvent(TrackEventCollectorStarted, nil), ErrTransient)
+	assert.ErrorIs(t, agent.TrackEvent(TrackEventRestartRequested, nil), ErrTransient)
+
+	names, err := agent.trackingQueue.names()
+	require.NoError(t, err)
+	assert.Len(t, names, 2)
+
+	// and sent in order before the next event
+	tracking.status = http.StatusOK
+	require.NoError(t, agent.TrackEvent(TrackEventShutdown, nil))
+	assert.Equal(t, []string{TrackEventCollectorStarted, TrackEventRestartRequested,
+		TrackEventShutdown}, tracking.statuses())
+
+	names, err = agent.trackingQueue.names()
+	require.NoError(t, err)
+	assert.Empty(t, names)
+
+	// the events rejected by the backend are not queued
+	tracking.status = http.StatusBadRequest
+	assert.Error(t, agent.TrackEvent(TrackEventShutdown, nil))
+	names, err = agent.trackingQueue.names()
+	require.NoError(t, err)
+	assert.Empty(t, names)
+
+	// the queue is also retried without a new event
+	tracking.status = http.StatusTooManyRequests
+	assert.Error(t, agent.TrackEvent(TrackEventCollectorStarted, nil))
+	tracking.status = http.StatusOK
+	agent.retryTrackingEvents()
+	assert.Len(t, tracking.events, 4)
+}
+
+func TestTrackingQueueLimit(t *testing.T) {
+	queue := newTrackingQueue(t.TempDir(), 2)
+
+	start := time.Now()
+	for i, status := range []string{TrackEventInstalled, TrackEventCollectorStarted, TrackEventShutdown} {
+		require.NoError(t, queue.push(TrackingPayload{
+			ID:        status,
+			Status:    status,
+			Timestamp: start.Add(time.Duration(i) * time.Second),
+		}))
+	}
+
+	// the oldest event is dropped
+	names, err := queue.names()
+	require.NoError(t, err)
+	require.Len(t, names, 2)
+
+	oldest, err := queue.read(names[0])
+	require.NoError(t, err)
+	assert.Equal(t, TrackEventCollectorStarted, oldest.Status)
+}
+
+func TestApplyConfigTrackEvent(t *testing.T) {
+	tracking, server := newTrackingServer(t)
+	agent := newTrackingAgent(t, server.URL)
+
+	oldData := []byte("receivers: {}\n")
+	require.NoError(t, os.WriteFile(agent.OtelConfigFile, oldData, 0644))
+	require.NoError(t, agent.applyConfig([]byte(sourceTestConfig)))
+	require.NoError(t, agent.FlushTrackingEvents(context.Background()))
+
+	require.Len(t, tracking.events, 1)
+	event := tracking.events[0]
+	assert.Equal(t, TrackEventConfigApplied, event.Status)
+	assert.Equal(t, configHash([]byte(sourceTestConfig)), event.ConfigHash)
+	assert.Equal(t, configHash(oldData), event.PreviousConfigHash)
+}
+
+func TestTrackEventAsync(t *testing.T) {
+	tracking, server := newTrackingServer(t)
+	agent := newTrackingAgent(t, server.URL)
+
+	agent.TrackEventAsync(TrackEventInstalled, nil)
+	agent.TrackEventAsync(TrackEventCollectorStarted, nil)
+	require.NoError(t, agent.FlushTrackingEvents(context.Background()))
+	assert.Equal(t, []string{TrackEventInstalled, TrackEventCollectorStarted},
+		tracking.statuses())
+
+	// the wait for a blackholed backend is bounded by the context
+	blocked := make(chan struct{})
+	blackhole := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-blocked
+	}))
+	t.Cleanup(blackhole.Close)
+	t.Cleanup(func() { close(blocked) })
+
+	agent = newTrackingAgent(t, blackhole.URL)
+	start := time.Now()
+	agent.TrackEventAsync(TrackEventShutdown, nil)
+	assert.Less(t, time.Since(start), time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, agent.FlushTrackingEvents(ctx), context.DeadlineExceeded)
+
+	// and so is closing the agent, which queues the pending event on disk
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, agent.Close(ctx), context.DeadlineExceeded)
+	names, err := agent.trackingQueue.names()
+	require.NoError(t, err)
+	assert.Len(t, names, 1)
+
+	// the events tracked after Close are dropped
+	agent.TrackEventAsync(TrackEventCollectorStarted, nil)
+	assert.NoError(t, agent.FlushTrackingEvents(context.Background()))
+	names, err = agent.trackingQueue.names()
+	require.NoError(t, err)
+	assert.Len(t, names, 1)
+}
+
+func TestCloseSendsTrackedEvents(t *testing.T) {
+	tracking, server := newTrackingServer(t)
+	agent := newTrackingAgent(t, server.URL)
+
+	agent.TrackEventAsync(TrackEventCollectorStopped, nil)
+	agent.TrackEventAsync(TrackEventShutdown, nil)
+	require.NoError(t, agent.Close(context.Background()))
+	assert.Equal(t, []string{TrackEventCollectorStopped, TrackEventShutdown},
+		tracking.statuses())
+
+	// closing again has nothing to wait for
+	assert.NoError(t, agent.Close(context.Background()))
+}
+
+func TestTrackEventOffline(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+	}))
+	t.Cleanup(server.Close)
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	bundleDir := t.TempDir()
+	writeTestBundle(t, bundleDir, map[string]string{
+		"otel-config-nodocker.yaml": testBundleConfig,
+	}, priv)
+
+	otelConfigFile := filepath.Join(t.TempDir(), "otel-config.yaml")
+	agent, err := NewHostAgent(HostConfig{
+		BaseConfig: BaseConfig{
+			APIKey:               "testAPIKey",
+			APIURLForConfigCheck: server.URL,
+			OtelConfigFile:       otelConfigFile,
+			AgentFeatures: AgentFeatures{
+				MetricCollection: true,
+			},
+		},
+		OfflineBundle:          bundleDir,
+		OfflineBundlePublicKey: base64.StdEncoding.EncodeToString(pub),
+	}, zapcore.NewNopCore())
+	require.NoError(t, err)
+
+	require.NoError(t, agent.ApplyOfflineBundle())
+	agent.TrackEventAsync(TrackEventInstalled, nil)
+	require.NoError(t, agent.TrackEvent(TrackEventShutdown, nil))
+	require.NoError(t, agent.FlushTrackingEvents(context.Background()))
+	agent.retryTrackingEvents()
+
+	assert.Zero(t, requests.Load())
+	assert.Nil(t, agent.trackingQueue)
+	_, err = os.Stat(filepath.Join(filepath.Dir(otelConfigFile), trackingQueueDirName))
+	assert.True(t, os.IsNotExist(err))
+}
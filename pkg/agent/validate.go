@@ -0,0 +1,200 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/confmap"
+)
+
+// ValidateConfigFile renders the otel config at path the way the agent
+// renders the config from Middleware backend, merging the integration
+// configs in integrationsDir (e.g. <integrationsDir>/postgresql.yaml) if it
+// is not empty, and validates the result against the collector components
+// of the agent. It returns every validation error found, each prefixed by
+// its location in the config (e.g. receivers::otlp).
+func (c *HostAgent) ValidateConfigFile(path string, integrationsDir string) []error {
+	config, err := readConfigFile(path)
+	if err != nil {
+		return []error{err}
+	}
+
+	integrationConfigs := map[IntegrationType]IntegrationConfig{}
+	if integrationsDir != "" {
+		for _, integrationType := range integrationTypes {
+			integrationPath := filepath.Join(integrationsDir, integrationType.String()+".yaml")
+			if _, err := os.Stat(integrationPath); err == nil {
+				integrationConfigs[integrationType] = IntegrationConfig{
+					Path: integrationPath,
+				}
+			}
+		}
+	}
+
+	data, _, err := c.renderConfig(config, integrationConfigs)
+	if err != nil {
+		return []error{err}
+	}
+
+	return c.validateConfig(data)
+}
+
+// validateConfig validates the given otel config YAML. Unlike
+// otelcol.Config.Validate, it doesn't stop at the first invalid component.
+func (c *HostAgent) validateConfig(data []byte) []error {
+	resolver, err := confmap.NewResolver(c.getConfigProviderSettings("yaml:" + string(data)).ResolverSettings)
+	if err != nil {
+		return []error{err}
+	}
+
+	conf, err := resolver.Resolve(context.Background())
+	if err != nil {
+		return []error{err}
+	}
+
+	factories, err := c.getFactories()
+	if err != nil {
+		return []error{err}
+	}
+
+	var errs []error
+	for _, kind := range []struct {
+		name      string
+		factories map[component.Type]component.Factory
+	}{
+		{"receivers", componentFactories(factories.Receivers)},
+		{"processors", componentFactories(factories.Processors)},
+		{"exporters", componentFactories(factories.Exporters)},
+		{"connectors", componentFactories(factories.Connectors)},
+		{"extensions", componentFactories(factories.Extensions)},
+	} {
+		errs = append(errs, validateComponents(conf, kind.name, kind.factories)...)
+	}
+
+	errs = append(errs, validateServiceReferences(conf.ToStringMap())...)
+	if len(errs) > 0 {
+		return errs
+	}
+
+	// catch the errors not covered above, e.g. missing receivers
+	cfg, err := c.loadConfig(data)
+	if err != nil {
+		return []error{err}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return []error{err}
+	}
+
+	return nil
+}
+
+func componentFactories[F component.Factory](m map[component.Type]F) map[component.Type]component.Factory {
+	factories := make(map[component.Type]component.Factory, len(m))
+	for t, f := range m {
+		factories[t] = f
+	}
+	return factories
+}
+
+// validateComponents unmarshals and validates every component of the given
+// kind (e.g. receivers) in conf.
+func validateComponents(conf *confmap.Conf, kind string,
+	factories map[component.Type]component.Factory) []error {
+	section, err := conf.Sub(kind)
+	if err != nil {
+		return []error{fmt.Errorf("%s: %w", kind, err)}
+	}
+
+	components := section.ToStringMap()
+	names := make([]string, 0, len(components))
+	for name := range components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		location := kind + confmap.KeyDelimiter + name
+
+		var id component.ID
+		if err := id.UnmarshalText([]byte(name)); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", location, err))
+			continue
+		}
+
+		factory, ok := factories[id.Type()]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: unknown type %q", location, id.Type()))
+			continue
+		}
+
+		sub, err := section.Sub(name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", location, err))
+			continue
+		}
+
+		cfg := factory.CreateDefaultConfig()
+		if err := sub.Unmarshal(&cfg); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", location, err))
+			continue
+		}
+
+		if err := component.ValidateConfig(cfg); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", location, err))
+		}
+	}
+
+	return errs
+}
+
+// validateServiceReferences checks that the pipelines and the service
+// extensions only reference configured components.
+func validateServiceReferences(config map[string]interface{}) []error {
+	defined := func(kinds ...string) map[string]struct{} {
+		names := map[string]struct{}{}
+		for _, kind := range kinds {
+			section, _ := config[kind].(map[string]interface{})
+			for name := range section {
+				names[name] = struct{}{}
+			}
+		}
+		return names
+	}
+
+	var errs []error
+	check := func(location string, refs interface{}, kind string, names map[string]struct{}) {
+		list, _ := refs.([]interface{})
+		for _, ref := range list {
+			if _, ok := names[fmt.Sprint(ref)]; !ok {
+				errs = append(errs, fmt.Errorf("%s: references %s %q which is not configured",
+					location, kind, ref))
+			}
+		}
+	}
+
+	service, _ := config[Service].(map[string]interface{})
+	check("service::extensions", service["extensions"], "extension", defined("extensions"))
+
+	pipelines, _ := service[Pipelines].(map[string]interface{})
+	pipelineNames := make([]string, 0, len(pipelines))
+	for name := range pipelines {
+		pipelineNames = append(pipelineNames, name)
+	}
+	sort.Strings(pipelineNames)
+
+	for _, name := range pipelineNames {
+		pipeline, _ := pipelines[name].(map[string]interface{})
+		location := "service::pipelines::" + name
+		check(location, pipeline[Receivers], "receiver", defined(Receivers, "connectors"))
+		check(location, pipeline["processors"], "processor", defined("processors"))
+		check(location, pipeline["exporters"], "exporter", defined("exporters", "connectors"))
+	}
+
+	return errs
+}
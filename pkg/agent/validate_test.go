@@ -0,0 +1,140 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+const validOtelConfig = `
+receivers:
+  otlp:
+    protocols:
+      grpc:
+        endpoint: 0.0.0.0:4317
+  hostmetrics:
+    collection_interval: 10s
+    scrapers:
+      cpu:
+processors:
+  batch:
+exporters:
+  otlp:
+    endpoint: ${env:MW_TARGET}
+service:
+  pipelines:
+    metrics:
+      receivers: [hostmetrics, otlp]
+      processors: [batch]
+      exporters: [otlp]
+    logs:
+      receivers: [otlp]
+      exporters: [otlp]
+`
+
+func writeOtelConfig(t *testing.T, config string) string {
+	path := filepath.Join(t.TempDir(), "otel-config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(config), 0644))
+	return path
+}
+
+func newValidateAgent(t *testing.T, opts ...HostOptions) *HostAgent {
+	agent, err := NewHostAgent(HostConfig{
+		BaseConfig: BaseConfig{
+			AgentFeatures: AgentFeatures{
+				MetricCollection: true,
+				LogCollection:    true,
+			},
+		},
+	}, zapcore.NewNopCore(), opts...)
+	require.NoError(t, err)
+	return agent
+}
+
+func TestValidateConfigFile(t *testing.T) {
+	t.Setenv("MW_TARGET", "https://example.com:443")
+
+	agent := newValidateAgent(t)
+	assert.Empty(t, agent.ValidateConfigFile(writeOtelConfig(t, validOtelConfig), ""))
+
+	// every invalid component is reported with its location
+	errs := agent.ValidateConfigFile(writeOtelConfig(t, `
+receivers:
+  otlp:
+    protocols:
+      grpc:
+        endpoint: 0.0.0.0:4317
+    invalid_field: true
+  unknownreceiver:
+  hostmetrics:
+    collection_interval: 10s
+    scrapers:
+      cpu:
+processors:
+  batch:
+exporters:
+  otlp:
+service:
+  pipelines:
+    metrics:
+      receivers: [hostmetrics, otlp, missing]
+      processors: [batch, missing]
+      exporters: [otlp]
+`), "")
+
+	var messages []string
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	require.Len(t, messages, 5, strings.Join(messages, "\n"))
+	assert.True(t, strings.HasPrefix(messages[0], "receivers::otlp: "))
+	assert.True(t, strings.HasPrefix(messages[1], "receivers::unknownreceiver: "))
+	assert.True(t, strings.HasPrefix(messages[2], "exporters::otlp: "))
+	assert.Equal(t, `service::pipelines::metrics: references receiver "missing" which is not configured`, messages[3])
+	assert.Equal(t, `service::pipelines::metrics: references processor "missing" which is not configured`, messages[4])
+
+	assert.Len(t, agent.ValidateConfigFile(filepath.Join(t.TempDir(), "missing.yaml"), ""), 1)
+}
+
+func TestValidateConfigFileRewrites(t *testing.T) {
+	t.Setenv("MW_TARGET", "https://example.com:443")
+
+	// the logs pipeline is removed if log collection is disabled
+	agent := newValidateAgent(t)
+	agent.AgentFeatures.LogCollection = false
+	assert.Empty(t, agent.ValidateConfigFile(writeOtelConfig(t, validOtelConfig+`
+    logs/file:
+      receivers: [filelog]
+      exporters: [otlp]
+`), ""))
+
+	// the ECS receiver is added on ECS
+	agent = newValidateAgent(t, WithHostAgentInfraPlatform(InfraPlatformECSFargate))
+	assert.Empty(t, agent.ValidateConfigFile(writeOtelConfig(t, validOtelConfig), ""))
+
+	// integration configs are merged into the receivers
+	integrationsDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(integrationsDir, "redis.yaml"), []byte(`
+otlp:
+  invalid_field: true
+`), 0644))
+
+	agent = newValidateAgent(t)
+	errs := agent.ValidateConfigFile(writeOtelConfig(t, validOtelConfig), integrationsDir)
+	require.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Error(), "receivers::otlp: ")
+}
+
+func TestParseInfraPlatform(t *testing.T) {
+	p, err := ParseInfraPlatform("ecsfargate")
+	assert.NoError(t, err)
+	assert.Equal(t, InfraPlatformECSFargate, p)
+
+	_, err = ParseInfraPlatform("mainframe")
+	assert.ErrorIs(t, err, ErrInvalidInfraPlatform)
+}